@@ -0,0 +1,150 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/wperron/o11yutil/config"
+)
+
+// publicPaths are served without authentication so that metrics can be
+// scraped and probes can check the process health without credentials.
+var publicPaths = map[string]bool{
+	"/metrics": true,
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// dashboardPaths accept a token in the `token` query parameter, since a
+// browser can't set the Authorization header when opening the dashboard or
+// its EventSource.
+var dashboardPaths = map[string]bool{
+	"/":       true,
+	"/events": true,
+}
+
+// WithAuth requires requests to authenticate with one of the credentials in
+// a, except for metrics and health checks. Read-only credentials can only use
+// safe methods.
+func WithAuth(a *config.Auth) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// WithTLS serves the API over HTTPS using c.
+func WithTLS(c *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = c
+	}
+}
+
+// TLSConfig loads the certificates referenced by c.
+func TLSConfig(c *config.TLS) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading key pair: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file %s: %w", c.ClientCAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA file %s", c.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if c.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if c.RequireClientCert {
+		return nil, errors.New("require_client_cert needs a client_ca_file")
+	}
+
+	return tlsConfig, nil
+}
+
+// authorize authenticates r and checks that its credentials grant access to
+// the route. It writes an error response and returns false otherwise.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.auth == nil || publicPaths[r.URL.Path] {
+		return true
+	}
+
+	role, ok := s.authenticate(r)
+	if !ok {
+		if len(s.auth.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="zombie"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="zombie"`)
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if role != config.RoleAdmin {
+			writeError(w, http.StatusForbidden, errors.New("admin role required"))
+			return false
+		}
+	}
+
+	return true
+}
+
+// authenticate returns the role of the credentials sent with r.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, u := range s.auth.Users {
+			if equal(u.Username, username) && equal(u.Password, password) {
+				return u.Role, true
+			}
+		}
+		return "", false
+	}
+
+	header := r.Header.Get("Authorization")
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return s.tokenRole(header[len("Bearer "):])
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" && dashboardPaths[r.URL.Path] {
+		return s.tokenRole(token)
+	}
+
+	return "", false
+}
+
+// tokenRole returns the role of token.
+func (s *Server) tokenRole(token string) (string, bool) {
+	for _, t := range s.auth.Tokens {
+		if equal(t.Token, token) {
+			return t.Role, true
+		}
+	}
+	return "", false
+}
+
+// equal compares secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -1,20 +1,388 @@
 // Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package api implements zombie's management API. On top of exposing
+// Prometheus metrics, it reports the status of the process and of every
+// target, lets operators pause, resume and scale targets at runtime, and
+// serves a live dashboard of every target at its root.
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wperron/o11yutil/client"
+	"github.com/wperron/o11yutil/config"
+	"github.com/wperron/o11yutil/runner"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func Serve(addr string) error {
-	http.Handle("/metrics", promhttp.HandlerFor(
-		prometheus.DefaultGatherer,
+// Runner is the subset of runner.Runner controlled by the API.
+type Runner interface {
+	Running() bool
+	Stats() []runner.TargetStats
+	Scale(name string, workers int) error
+	Pause(name string) error
+	Resume(name string) error
+}
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version  string `json:"version"`
+	Branch   string `json:"branch"`
+	Revision string `json:"revision"`
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithTraceURL sets the URL used to link failed requests to their trace. The
+// `{traceID}` placeholder is replaced by the request's trace ID, e.g.
+// `http://localhost:3200/api/traces/{traceID}`.
+func WithTraceURL(u string) Option {
+	return func(s *Server) {
+		s.traceURL = u
+	}
+}
+
+// Server serves the management API.
+type Server struct {
+	runner    Runner
+	info      BuildInfo
+	traceURL  string
+	auth      *config.Auth
+	tlsConfig *tls.Config
+	started   time.Time
+	mux       *http.ServeMux
+	srv       *http.Server
+
+	// done is closed when the server shuts down, to end long-lived
+	// responses like the dashboard's event stream.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// eventInterval is the interval between dashboard updates.
+	eventInterval time.Duration
+}
+
+// New creates a Server controlling r and exposing the metrics gathered by g.
+func New(r Runner, g prometheus.Gatherer, info BuildInfo, opts ...Option) *Server {
+	s := &Server{
+		runner:        r,
+		info:          info,
+		started:       time.Now(),
+		mux:           http.NewServeMux(),
+		eventInterval: defaultEventInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.Handle("/metrics", promhttp.HandlerFor(
+		g,
 		promhttp.HandlerOpts{
 			// Opt into OpenMetrics to support exemplars.
 			EnableOpenMetrics: true,
 		},
 	))
-	return http.ListenAndServe(addr, nil)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/targets", s.handleTargets)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/", s.handleIndex)
+
+	s.done = make(chan struct{})
+	s.srv = &http.Server{Handler: s, TLSConfig: s.tlsConfig}
+	s.srv.RegisterOnShutdown(func() {
+		s.doneOnce.Do(func() { close(s.done) })
+	})
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+
+	// Target names default to their URL, so they are matched on the escaped
+	// path before the mux gets a chance to clean the slashes out of them.
+	if strings.HasPrefix(r.URL.EscapedPath(), "/targets/") {
+		s.handleTargetAction(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until Shutdown is called. The API is
+// served over HTTPS if the Server was created WithTLS.
+func (s *Server) ListenAndServe(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		return s.srv.ServeTLS(lis, "", "")
+	}
+	return s.srv.Serve(lis)
+}
+
+// Shutdown gracefully stops the server. ListenAndServe returns
+// http.ErrServerClosed once called, even if it is called afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+type statusResponse struct {
+	BuildInfo
+	Started       time.Time `json:"started"`
+	Uptime        string    `json:"uptime"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Running       bool      `json:"running"`
+}
+
+type targetResponse struct {
+	Name     string        `json:"name"`
+	Config   targetConfig  `json:"config"`
+	Stats    targetStats   `json:"stats"`
+	Recent   []resultEntry `json:"recent"`
+	Failures []resultEntry `json:"failures"`
+}
+
+// targetConfig omits the target's headers since they may hold credentials.
+type targetConfig struct {
+	Url     string  `json:"url"`
+	Delay   int64   `json:"delay"`
+	Jitter  float64 `json:"jitter"`
+	Workers int     `json:"workers"`
+}
+
+type targetStats struct {
+	Workers   int             `json:"workers"`
+	Paused    bool            `json:"paused"`
+	Requests  uint64          `json:"requests"`
+	Errors    uint64          `json:"errors"`
+	RPS       float64         `json:"rps"`
+	ErrorRate float64         `json:"error_rate"`
+	Latency   latencyResponse `json:"latency"`
+}
+
+// latencyResponse holds latency percentiles in milliseconds.
+type latencyResponse struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
+}
+
+type resultEntry struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Status   int       `json:"status"`
+	Latency  int       `json:"latency"`
+	TraceID  string    `json:"trace_id"`
+	TraceURL string    `json:"trace_url,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type scaleRequest struct {
+	Workers *int `json:"workers"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !s.runner.Running() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	uptime := time.Since(s.started)
+	writeJSON(w, http.StatusOK, statusResponse{
+		BuildInfo:     s.info,
+		Started:       s.started,
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: uptime.Seconds(),
+		Running:       s.runner.Running(),
+	})
+}
+
+func (s *Server) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	stats := s.runner.Stats()
+	targets := make([]targetResponse, 0, len(stats))
+	for _, t := range stats {
+		targets = append(targets, s.toTargetResponse(t))
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// handleTargetAction serves `POST /targets/{name}/{pause,resume,scale}`.
+func (s *Server) handleTargetAction(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), "/targets/")
+	i := strings.LastIndex(rest, "/")
+	if i <= 0 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	name, err := url.PathUnescape(rest[:i])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid target name: %w", err))
+		return
+	}
+
+	action := rest[i+1:]
+	switch action {
+	case "pause", "resume", "scale":
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	switch action {
+	case "pause":
+		err = s.runner.Pause(name)
+	case "resume":
+		err = s.runner.Resume(name)
+	case "scale":
+		var req scaleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+			return
+		}
+		if req.Workers == nil {
+			writeError(w, http.StatusBadRequest, errors.New("missing workers"))
+			return
+		}
+		if *req.Workers < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid number of workers %d", *req.Workers))
+			return
+		}
+		err = s.runner.Scale(name, *req.Workers)
+	}
+
+	switch {
+	case errors.Is(err, runner.ErrUnknownTarget):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, runner.ErrNotStarted):
+		writeError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, t := range s.runner.Stats() {
+		if t.Name == name {
+			writeJSON(w, http.StatusOK, s.toTargetResponse(t))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("%w %s", runner.ErrUnknownTarget, name))
+}
+
+func (s *Server) toTargetResponse(t runner.TargetStats) targetResponse {
+	workers := t.Config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	return targetResponse{
+		Name: t.Name,
+		Config: targetConfig{
+			Url:     t.URL,
+			Delay:   t.Config.Duration().Milliseconds(),
+			Jitter:  t.Config.Jitter,
+			Workers: workers,
+		},
+		Stats: targetStats{
+			Workers:   t.Workers,
+			Paused:    t.Paused,
+			Requests:  t.Requests,
+			Errors:    t.Errors,
+			RPS:       t.RPS,
+			ErrorRate: t.ErrorRate,
+			Latency: latencyResponse{
+				P50: t.Latency.P50.Milliseconds(),
+				P90: t.Latency.P90.Milliseconds(),
+				P99: t.Latency.P99.Milliseconds(),
+			},
+		},
+		Recent:   s.toResultEntries(t.Recent),
+		Failures: s.toResultEntries(t.Failures),
+	}
+}
+
+func (s *Server) toResultEntries(rs []client.Result) []resultEntry {
+	entries := make([]resultEntry, 0, len(rs))
+	for _, res := range rs {
+		e := resultEntry{
+			Time:    res.Time,
+			Method:  res.Method,
+			Status:  res.Status,
+			Latency: res.Latency,
+			TraceID: res.TraceID,
+		}
+		if res.Err != nil {
+			e.Error = res.Err.Error()
+		}
+		// Only link to traces that were exported: unsampled requests carry no
+		// trace ID, and a zero trace ID never identifies a trace.
+		if _, err := trace.TraceIDFromHex(res.TraceID); s.traceURL != "" && err == nil {
+			e.TraceURL = strings.ReplaceAll(s.traceURL, "{traceID}", res.TraceID)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
 }
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/client"
+	"github.com/wperron/o11yutil/config"
+	"github.com/wperron/o11yutil/runner"
+)
+
+type fakeRunner struct {
+	running bool
+	targets []runner.TargetStats
+}
+
+func (f *fakeRunner) Running() bool               { return f.running }
+func (f *fakeRunner) Stats() []runner.TargetStats { return f.targets }
+
+func (f *fakeRunner) Scale(name string, workers int) error {
+	t, err := f.target(name)
+	if err != nil {
+		return err
+	}
+	t.Workers = workers
+	return nil
+}
+
+func (f *fakeRunner) Pause(name string) error {
+	t, err := f.target(name)
+	if err != nil {
+		return err
+	}
+	t.Paused = true
+	return nil
+}
+
+func (f *fakeRunner) Resume(name string) error {
+	t, err := f.target(name)
+	if err != nil {
+		return err
+	}
+	t.Paused = false
+	return nil
+}
+
+func (f *fakeRunner) target(name string) (*runner.TargetStats, error) {
+	if !f.running {
+		return nil, runner.ErrNotStarted
+	}
+	for i := range f.targets {
+		if f.targets[i].Name == name {
+			return &f.targets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w %s", runner.ErrUnknownTarget, name)
+}
+
+func newTestServer() (*fakeRunner, *Server) {
+	r := &fakeRunner{
+		running: true,
+		targets: []runner.TargetStats{
+			{
+				Name:    "foo",
+				URL:     "http://foo.org",
+				Config:  config.Target{Url: "http://foo.org", Name: "foo", Workers: 2},
+				Workers: 2,
+			},
+			{
+				Name:    "http://bar.org",
+				URL:     "http://bar.org",
+				Config:  config.Target{Url: "http://bar.org"},
+				Workers: 1,
+			},
+		},
+	}
+	return r, New(r, prometheus.NewRegistry(), BuildInfo{Version: "v1.0.0"})
+}
+
+func do(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestServer(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		_, s := newTestServer()
+		rec := do(s, http.MethodGet, "/status", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+
+		var status statusResponse
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+		if status.Version != "v1.0.0" || !status.Running {
+			t.Errorf("unexpected status %+v", status)
+		}
+	})
+
+	t.Run("readiness", func(t *testing.T) {
+		r, s := newTestServer()
+		if rec := do(s, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+			t.Errorf("expected healthz 200, got %d", rec.Code)
+		}
+		if rec := do(s, http.MethodGet, "/readyz", ""); rec.Code != http.StatusOK {
+			t.Errorf("expected readyz 200, got %d", rec.Code)
+		}
+		r.running = false
+		if rec := do(s, http.MethodGet, "/readyz", ""); rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected readyz 503 when not running, got %d", rec.Code)
+		}
+	})
+
+	t.Run("targets", func(t *testing.T) {
+		_, s := newTestServer()
+		rec := do(s, http.MethodGet, "/targets", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", rec.Code)
+		}
+
+		var targets []targetResponse
+		if err := json.NewDecoder(rec.Body).Decode(&targets); err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+		if len(targets) != 2 {
+			t.Fatalf("expected 2 targets, got %d", len(targets))
+		}
+		if targets[1].Config.Workers != 1 || targets[1].Config.Delay != 1000 {
+			t.Errorf("expected defaulted config for unnamed target, got %+v", targets[1].Config)
+		}
+
+		if rec := do(s, http.MethodPost, "/targets", ""); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected 405 posting to /targets, got %d", rec.Code)
+		}
+	})
+
+	t.Run("actions", func(t *testing.T) {
+		r, s := newTestServer()
+
+		if rec := do(s, http.MethodPost, "/targets/foo/pause", ""); rec.Code != http.StatusOK || !r.targets[0].Paused {
+			t.Errorf("expected target to be paused, got %d %s", rec.Code, rec.Body)
+		}
+		if rec := do(s, http.MethodPost, "/targets/foo/resume", ""); rec.Code != http.StatusOK || r.targets[0].Paused {
+			t.Errorf("expected target to be resumed, got %d %s", rec.Code, rec.Body)
+		}
+
+		path := "/targets/" + url.PathEscape("http://bar.org") + "/scale"
+		rec := do(s, http.MethodPost, path, `{"workers": 4}`)
+		if rec.Code != http.StatusOK || r.targets[1].Workers != 4 {
+			t.Errorf("expected target to be scaled to 4, got %d %s", rec.Code, rec.Body)
+		}
+
+		cases := []struct {
+			method, path, body string
+			code               int
+		}{
+			{http.MethodGet, "/targets/foo/pause", "", http.StatusMethodNotAllowed},
+			{http.MethodPost, "/targets/foo/restart", "", http.StatusNotFound},
+			{http.MethodPost, "/targets/missing/pause", "", http.StatusNotFound},
+			{http.MethodPost, "/targets/foo/scale", `{}`, http.StatusBadRequest},
+			{http.MethodPost, "/targets/foo/scale", `{"workers": -1}`, http.StatusBadRequest},
+		}
+		for _, c := range cases {
+			if rec := do(s, c.method, c.path, c.body); rec.Code != c.code {
+				t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.code, rec.Code)
+			}
+		}
+
+		r.running = false
+		if rec := do(s, http.MethodPost, "/targets/foo/pause", ""); rec.Code != http.StatusConflict {
+			t.Errorf("expected 409 when runner isn't started, got %d", rec.Code)
+		}
+	})
+}
+
+func TestDashboard(t *testing.T) {
+	t.Run("index", func(t *testing.T) {
+		_, s := newTestServer()
+		rec := do(s, http.MethodGet, "/", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "EventSource") {
+			t.Errorf("expected dashboard page, got %d", rec.Code)
+		}
+		if rec := do(s, http.MethodGet, "/missing", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for unknown path, got %d", rec.Code)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		r, _ := newTestServer()
+		r.targets[0].Failures = []client.Result{
+			{Status: 500, TraceID: "0102030405060708090a0b0c0d0e0f10"},
+			{Status: 500},
+			{Status: 500, TraceID: "00000000000000000000000000000000"},
+		}
+		s := New(r, prometheus.NewRegistry(), BuildInfo{}, WithTraceURL("http://tempo/{traceID}"))
+		s.eventInterval = time.Millisecond
+
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+
+		res, err := http.Get(srv.URL + "/events")
+		if err != nil {
+			t.Fatalf("failed to connect to event stream: %s", err)
+		}
+		defer res.Body.Close()
+
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("expected event stream content type, got %s", ct)
+		}
+
+		scanner := bufio.NewScanner(res.Body)
+		events := 0
+		for events < 2 && scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			events++
+
+			var targets []targetResponse
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &targets); err != nil {
+				t.Fatalf("failed to decode event: %s", err)
+			}
+			failures := targets[0].Failures
+			if got := failures[0].TraceURL; got != "http://tempo/0102030405060708090a0b0c0d0e0f10" {
+				t.Errorf("expected trace link http://tempo/0102030405060708090a0b0c0d0e0f10, got %s", got)
+			}
+			for _, f := range failures[1:] {
+				if f.TraceURL != "" {
+					t.Errorf("expected no trace link for trace ID %q, got %s", f.TraceID, f.TraceURL)
+				}
+			}
+		}
+		if events != 2 {
+			t.Errorf("expected 2 events, got %d", events)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		_, s := newTestServer()
+		s.eventInterval = time.Millisecond
+
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+
+		res, err := http.Get(srv.URL + "/events")
+		if err != nil {
+			t.Fatalf("failed to connect to event stream: %s", err)
+		}
+		defer res.Body.Close()
+
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		ended := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(io.Discard, res.Body)
+			close(ended)
+		}()
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the event stream to end on shutdown")
+		}
+	})
+}
+
+func TestAuth(t *testing.T) {
+	r, _ := newTestServer()
+	s := New(r, prometheus.NewRegistry(), BuildInfo{}, WithAuth(&config.Auth{
+		Tokens: []config.Token{
+			{Token: "reader", Role: config.RoleRead},
+			{Token: "admin", Role: config.RoleAdmin},
+		},
+		Users: []config.User{
+			{Username: "ops", Password: "secret", Role: config.RoleAdmin},
+		},
+	}))
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		auth   func(*http.Request)
+		code   int
+	}{
+		{"metrics are public", http.MethodGet, "/metrics", nil, http.StatusOK},
+		{"health is public", http.MethodGet, "/healthz", nil, http.StatusOK},
+		{"missing credentials", http.MethodGet, "/targets", nil, http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/targets", bearer("nope"), http.StatusUnauthorized},
+		{"read token", http.MethodGet, "/targets", bearer("reader"), http.StatusOK},
+		{"read token on admin route", http.MethodPost, "/targets/foo/pause", bearer("reader"), http.StatusForbidden},
+		{"admin token", http.MethodPost, "/targets/foo/pause", bearer("admin"), http.StatusOK},
+		{"basic auth", http.MethodPost, "/targets/foo/resume", basic("ops", "secret"), http.StatusOK},
+		{"wrong password", http.MethodGet, "/status", basic("ops", "wrong"), http.StatusUnauthorized},
+		{"dashboard token", http.MethodGet, "/?token=reader", nil, http.StatusOK},
+		{"invalid dashboard token", http.MethodGet, "/?token=nope", nil, http.StatusUnauthorized},
+		{"query token on API route", http.MethodGet, "/targets?token=reader", nil, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, nil)
+			if c.auth != nil {
+				c.auth(req)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != c.code {
+				t.Errorf("expected %d, got %d", c.code, rec.Code)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Shutting down before serving, as when a signal arrives early, stops
+	// ListenAndServe right away.
+	_, s := newTestServer()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := s.ListenAndServe("127.0.0.1:0"); err != http.ErrServerClosed {
+		t.Errorf("expected %v, got %v", http.ErrServerClosed, err)
+	}
+
+	_, s = newTestServer()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ListenAndServe("127.0.0.1:0")
+	}()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Errorf("expected %v, got %v", http.ErrServerClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected ListenAndServe to return after Shutdown")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	if _, err := TLSConfig(&config.TLS{CertFile: "missing.crt", KeyFile: "missing.key"}); err == nil {
+		t.Errorf("expected error loading missing key pair")
+	}
+
+	dir := t.TempDir()
+	ca, caKey := newCert(t, dir, "ca", nil, nil)
+	newCert(t, dir, "server", ca, caKey)
+	newCert(t, dir, "client", ca, caKey)
+
+	tlsConfig, err := TLSConfig(&config.TLS{
+		CertFile:          filepath.Join(dir, "server.crt"),
+		KeyFile:           filepath.Join(dir, "server.key"),
+		ClientCAFile:      filepath.Join(dir, "ca.crt"),
+		RequireClientCert: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to load TLS config: %s", err)
+	}
+
+	_, s := newTestServer()
+	srv := httptest.NewUnstartedServer(s)
+	srv.TLS = tlsConfig
+	// Silence the handshake error logged for the client without a certificate.
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatalf("failed to load client key pair: %s", err)
+	}
+
+	get := func(certs ...tls.Certificate) error {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		res, err := c.Get(srv.URL + "/healthz")
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	if err := get(); err == nil {
+		t.Errorf("expected a client without a certificate to be rejected")
+	}
+	if err := get(clientCert); err != nil {
+		t.Errorf("expected a client with a certificate to be accepted, got %s", err)
+	}
+}
+
+// newCert writes a certificate and key for 127.0.0.1 to dir, signed by parent
+// or self-signed as a CA if parent is nil.
+func newCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+
+	files := map[string]*pem.Block{
+		name + ".crt": {Type: "CERTIFICATE", Bytes: der},
+		name + ".key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for file, block := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %s", file, err)
+		}
+	}
+	return cert, key
+}
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func basic(username, password string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.SetBasicAuth(username, password)
+	}
+}
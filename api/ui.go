@@ -0,0 +1,82 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultEventInterval is the interval between dashboard updates.
+const defaultEventInterval = time.Second
+
+//go:embed ui
+var uiFS embed.FS
+
+// handleIndex serves the dashboard.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path))
+		return
+	}
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	page, err := uiFS.ReadFile("ui/index.html")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+// handleEvents streams the state of every target as server-sent events until
+// the client disconnects or the server shuts down.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(s.eventInterval)
+	defer ticker.Stop()
+
+	for {
+		stats := s.runner.Stats()
+		targets := make([]targetResponse, 0, len(stats))
+		for _, t := range stats {
+			targets = append(targets, s.toTargetResponse(t))
+		}
+
+		data, err := json.Marshal(targets)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: targets\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package chaos injects faults in HTTP servers: server errors, added latency,
+// hangs, connection resets, panics and slow response bodies. Faults are
+// either injected randomly according to a Config, or forced per request with
+// headers, which makes it possible to exercise alerting and error-trace
+// pipelines on demand.
+package chaos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wperron/o11yutil/topology"
+	"gopkg.in/yaml.v3"
+)
+
+// Headers forcing a fault on a single request, when enabled.
+const (
+	// HeaderError forces an error response with the given status code.
+	HeaderError = "X-Chaos-Error"
+
+	// HeaderLatency adds the given latency, expressed as a Go duration.
+	HeaderLatency = "X-Chaos-Latency"
+
+	// HeaderHang holds the request until the client gives up, or for the
+	// given duration if it is a valid Go duration.
+	HeaderHang = "X-Chaos-Hang"
+
+	// HeaderReset resets the connection without answering.
+	HeaderReset = "X-Chaos-Reset"
+
+	// HeaderPanic makes the handler panic.
+	HeaderPanic = "X-Chaos-Panic"
+
+	// HeaderSlowBody streams the response body one byte at a time, waiting
+	// the given Go duration between each byte.
+	HeaderSlowBody = "X-Chaos-Slow-Body"
+)
+
+// Config of the faults injected. Percentages are expressed between 0 and 100
+// and durations in milliseconds.
+type Config struct {
+	// ErrorPercent of requests answered with a server error.
+	ErrorPercent float64 `yaml:"error_percent"`
+
+	// ErrorCodes to pick from when injecting an error. Defaults to 500.
+	ErrorCodes []int `yaml:"error_codes,omitempty"`
+
+	// Latency added to requests.
+	Latency *Latency `yaml:"latency,omitempty"`
+
+	// HangPercent of requests held without an answer for HangDuration, or
+	// until the client gives up if HangDuration is 0.
+	HangPercent  float64 `yaml:"hang_percent"`
+	HangDuration int64   `yaml:"hang_duration,omitempty"`
+
+	// ResetPercent of connections reset without an answer.
+	ResetPercent float64 `yaml:"reset_percent"`
+
+	// PanicPercent of requests making the handler panic.
+	PanicPercent float64 `yaml:"panic_percent"`
+
+	// SlowBodyPercent of responses streamed one byte at a time, waiting
+	// SlowBodyDelay between each byte. SlowBodyDelay defaults to 100ms.
+	SlowBodyPercent float64 `yaml:"slow_body_percent"`
+	SlowBodyDelay   int64   `yaml:"slow_body_delay,omitempty"`
+
+	// Headers enables forcing faults per request with the X-Chaos-* headers.
+	Headers bool `yaml:"headers"`
+}
+
+// Latency distribution added to requests.
+type Latency struct {
+	// Percent of requests delayed. Defaults to 100.
+	Percent *float64 `yaml:"percent,omitempty"`
+
+	// Duration of the added latency. Its fields sit directly under `latency`
+	// in YAML, e.g. `distribution` and `mean`.
+	Duration topology.Distribution `yaml:",inline"`
+}
+
+const defaultSlowBodyDelay = 100 * time.Millisecond
+
+func Load(s string) (*Config, error) {
+	cfg := &Config{}
+
+	if err := yaml.Unmarshal([]byte(s), cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadFile(fp string) (*Config, error) {
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %s", fp, err)
+	}
+
+	cfg, err := Load(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file: %s", err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that percentages, status codes and distributions are valid.
+func (c *Config) Validate() error {
+	percents := map[string]float64{
+		"error_percent":     c.ErrorPercent,
+		"hang_percent":      c.HangPercent,
+		"reset_percent":     c.ResetPercent,
+		"panic_percent":     c.PanicPercent,
+		"slow_body_percent": c.SlowBodyPercent,
+	}
+	if c.Latency != nil && c.Latency.Percent != nil {
+		percents["latency.percent"] = *c.Latency.Percent
+	}
+	for k, v := range percents {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s must be between 0 and 100, got %f", k, v)
+		}
+	}
+
+	for _, code := range c.ErrorCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid error code %d", code)
+		}
+	}
+
+	if c.Latency != nil {
+		if err := c.Latency.Duration.Validate(); err != nil {
+			return fmt.Errorf("latency: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Injector injects faults in HTTP handlers.
+type Injector struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New creates an Injector for cfg.
+func New(cfg Config) *Injector {
+	if len(cfg.ErrorCodes) == 0 {
+		cfg.ErrorCodes = []int{http.StatusInternalServerError}
+	}
+	return &Injector{
+		cfg: cfg,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Handler wraps next, injecting faults before it gets called.
+func (in *Injector) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := in.faults(r)
+
+		if f.panic {
+			panic("chaos: injected panic")
+		}
+
+		if f.reset {
+			reset(w)
+			return
+		}
+
+		if f.hang {
+			var timeout <-chan time.Time
+			if f.hangFor > 0 {
+				timeout = time.After(f.hangFor)
+			}
+			select {
+			case <-r.Context().Done():
+			case <-timeout:
+			}
+			return
+		}
+
+		if f.latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(f.latency):
+			}
+		}
+
+		if f.errorCode != 0 {
+			http.Error(w, http.StatusText(f.errorCode), f.errorCode)
+			return
+		}
+
+		if f.slowBody > 0 {
+			w = &slowWriter{ResponseWriter: w, delay: f.slowBody}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faults selected for a single request.
+type faults struct {
+	panic     bool
+	reset     bool
+	hang      bool
+	hangFor   time.Duration
+	latency   time.Duration
+	errorCode int
+	slowBody  time.Duration
+}
+
+// faults rolls the dice for every fault, then applies the overrides from the
+// request headers if enabled.
+func (in *Injector) faults(r *http.Request) faults {
+	in.mu.Lock()
+	f := faults{
+		panic: in.roll(in.cfg.PanicPercent),
+		reset: in.roll(in.cfg.ResetPercent),
+		hang:  in.roll(in.cfg.HangPercent),
+	}
+	f.hangFor = time.Duration(in.cfg.HangDuration) * time.Millisecond
+	if in.roll(in.cfg.ErrorPercent) {
+		f.errorCode = in.cfg.ErrorCodes[in.rnd.Intn(len(in.cfg.ErrorCodes))]
+	}
+	if in.roll(in.cfg.SlowBodyPercent) {
+		f.slowBody = defaultSlowBodyDelay
+		if in.cfg.SlowBodyDelay > 0 {
+			f.slowBody = time.Duration(in.cfg.SlowBodyDelay) * time.Millisecond
+		}
+	}
+	if l := in.cfg.Latency; l != nil && (l.Percent == nil || in.roll(*l.Percent)) {
+		f.latency = l.Duration.Sample(in.rnd)
+	}
+	in.mu.Unlock()
+
+	if !in.cfg.Headers {
+		return f
+	}
+
+	h := r.Header
+	if v := h.Get(HeaderError); v != "" {
+		if code, err := strconv.Atoi(v); err == nil && code >= 100 && code <= 599 {
+			f.errorCode = code
+		}
+	}
+	if d, err := time.ParseDuration(h.Get(HeaderLatency)); err == nil {
+		f.latency = d
+	}
+	if v := h.Get(HeaderHang); v != "" {
+		f.hang = true
+		if d, err := time.ParseDuration(v); err == nil {
+			f.hangFor = d
+		}
+	}
+	if h.Get(HeaderReset) != "" {
+		f.reset = true
+	}
+	if h.Get(HeaderPanic) != "" {
+		f.panic = true
+	}
+	if d, err := time.ParseDuration(h.Get(HeaderSlowBody)); err == nil {
+		f.slowBody = d
+	}
+
+	return f
+}
+
+// roll returns true percent% of the time. in.mu must be held.
+func (in *Injector) roll(percent float64) bool {
+	return percent > 0 && in.rnd.Float64()*100 < percent
+}
+
+// reset closes the connection abruptly. When the connection can be hijacked
+// it is closed with a TCP RST, otherwise the handler is aborted which also
+// closes the connection without a response.
+func reset(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// slowWriter streams the response body one byte at a time.
+type slowWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	flusher, _ := w.ResponseWriter.(http.Flusher)
+	for i := range b {
+		if _, err := w.ResponseWriter.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		time.Sleep(w.delay)
+	}
+	return len(b), nil
+}
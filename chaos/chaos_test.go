@@ -0,0 +1,128 @@
+package chaos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wperron/o11yutil/topology"
+)
+
+var hello = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("hello"))
+})
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(`
+error_percent: 10
+error_codes: [502, 503]
+latency:
+  distribution: uniform
+  min: 100
+  max: 200
+headers: true
+`)
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	if cfg.ErrorPercent != 10 || len(cfg.ErrorCodes) != 2 || cfg.Latency.Duration.Max != 200 || !cfg.Headers {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	invalid := []string{
+		`error_percent: 120`,
+		`error_codes: [42]`,
+		`latency: {distribution: pareto}`,
+		`latency: {distribution: uniform, min: 10, max: 5}`,
+	}
+	for _, s := range invalid {
+		if _, err := Load(s); err == nil {
+			t.Errorf("expected error loading %q", s)
+		}
+	}
+}
+
+func TestInjector(t *testing.T) {
+	t.Run("no faults", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		New(Config{}).Handler(hello).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+			t.Errorf("expected untouched response, got %d %q", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		in := New(Config{ErrorPercent: 100, ErrorCodes: []int{http.StatusServiceUnavailable}})
+		in.Handler(hello).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503, got %d", rec.Code)
+		}
+	})
+
+	t.Run("fixed latency", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		in := New(Config{Latency: &Latency{Duration: topology.Distribution{Distribution: "fixed", Mean: 20}}})
+		start := time.Now()
+		in.Handler(hello).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("expected at least 20ms of latency, got %s", elapsed)
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected handler to panic")
+			}
+		}()
+		New(Config{PanicPercent: 100}).Handler(hello).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		in := New(Config{Headers: true})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderError, "504")
+		rec := httptest.NewRecorder()
+		in.Handler(hello).ServeHTTP(rec, req)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("expected 504 forced by header, got %d", rec.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderHang, "10ms")
+		rec = httptest.NewRecorder()
+		in.Handler(hello).ServeHTTP(rec, req)
+		if rec.Body.Len() != 0 {
+			t.Errorf("expected hanging request not to reach the handler")
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderSlowBody, "1ms")
+		rec = httptest.NewRecorder()
+		in.Handler(hello).ServeHTTP(rec, req)
+		if rec.Body.String() != "hello" {
+			t.Errorf("expected slow body to be complete, got %q", rec.Body)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderError, "500")
+		rec = httptest.NewRecorder()
+		New(Config{}).Handler(hello).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected headers to be ignored when disabled, got %d", rec.Code)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		srv := httptest.NewServer(New(Config{ResetPercent: 100}).Handler(hello))
+		defer srv.Close()
+
+		if res, err := http.Get(srv.URL); err == nil {
+			res.Body.Close()
+			t.Errorf("expected connection to be reset, got %s", res.Status)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package client
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/internal/promutil"
+)
+
+// MetricsOpts configures the metrics created by NewMetrics. The zero value
+// creates the metrics with their default names and buckets.
+type MetricsOpts struct {
+	// Namespace and Subsystem are prepended to every metric name.
+	Namespace string
+	Subsystem string
+
+	// ConstLabels are added to every metric.
+	ConstLabels prometheus.Labels
+
+	// Buckets of the request, DNS and TLS latency histograms. Each defaults to
+	// buckets suited to the expected durations when left empty.
+	RequestBuckets []float64
+	DNSBuckets     []float64
+	TLSBuckets     []float64
+}
+
+// Metrics holds the Prometheus collectors used to instrument pingers. A single
+// Metrics can be shared by any number of pingers.
+type Metrics struct {
+	InFlight       *prometheus.GaugeVec
+	Requests       *prometheus.CounterVec
+	DNSLatency     *prometheus.HistogramVec
+	TLSLatency     *prometheus.HistogramVec
+	RequestLatency *prometheus.HistogramVec
+}
+
+// NewMetrics creates the client metrics and registers them with reg. If
+// identical metrics are already registered with reg, those are reused so
+// that several callers can share a registry.
+func NewMetrics(reg prometheus.Registerer, opts MetricsOpts) (*Metrics, error) {
+	if opts.RequestBuckets == nil {
+		opts.RequestBuckets = prometheus.DefBuckets
+	}
+	if opts.DNSBuckets == nil {
+		opts.DNSBuckets = []float64{.005, .01, .025, .05}
+	}
+	if opts.TLSBuckets == nil {
+		opts.TLSBuckets = []float64{.05, .1, .25, .5}
+	}
+
+	m := &Metrics{
+		InFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "client_in_flight_requests",
+				Help:        "A gauge of in-flight requests for the wrapped client.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"target"},
+		),
+
+		Requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "client_api_requests_total",
+				Help:        "A counter for requests from the wrapped client.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"target", "code", "method"},
+		),
+
+		// DNSLatency has an instance label "event", which is set in the
+		// DNSStart and DNSDone hook functions of an httptrace.ClientTrace.
+		DNSLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "dns_duration_seconds",
+				Help:        "Trace dns latency histogram.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.DNSBuckets,
+			},
+			[]string{"target", "event"},
+		),
+
+		// TLSLatency has an instance label "event", which is set in the
+		// TLSHandshakeStart and TLSHandshakeDone hook functions of an
+		// httptrace.ClientTrace.
+		TLSLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "tls_duration_seconds",
+				Help:        "Trace tls latency histogram.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.TLSBuckets,
+			},
+			[]string{"target", "event"},
+		),
+
+		RequestLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "request_duration_seconds",
+				Help:        "A histogram of request latencies.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.RequestBuckets,
+			},
+			[]string{"target"},
+		),
+	}
+
+	c, err := promutil.Register(reg, m.InFlight)
+	if err != nil {
+		return nil, err
+	}
+	m.InFlight = c.(*prometheus.GaugeVec)
+
+	if c, err = promutil.Register(reg, m.Requests); err != nil {
+		return nil, err
+	}
+	m.Requests = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, m.DNSLatency); err != nil {
+		return nil, err
+	}
+	m.DNSLatency = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, m.TLSLatency); err != nil {
+		return nil, err
+	}
+	m.TLSLatency = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, m.RequestLatency); err != nil {
+		return nil, err
+	}
+	m.RequestLatency = c.(*prometheus.HistogramVec)
+
+	return m, nil
+}
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
@@ -13,6 +12,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/wperron/o11yutil/config"
+	"github.com/wperron/o11yutil/internal/promutil"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -26,115 +26,79 @@ var (
 	DefaultPinger = &pinger{
 		client: http.DefaultClient,
 	}
-
-	inFlightGauge  *prometheus.GaugeVec
-	requestCounter *prometheus.CounterVec
-	dnsLatencyVec  *prometheus.HistogramVec
-	tlsLatencyVec  *prometheus.HistogramVec
-	reqLatencyVec  *prometheus.HistogramVec
 )
 
 type Pinger interface {
-	Ping(context.Context, config.Target)
+	Ping(context.Context, config.Target, *url.URL)
 }
 
 type pinger struct {
-	client *http.Client
-	tracer trace.Tracer
+	client   *http.Client
+	tracer   trace.Tracer
+	onResult func(Result)
+}
+
+// PingerOption configures optional behavior of an instrumented pinger.
+type PingerOption func(*pinger)
+
+// WithResultHandler registers a function called with the Result of every
+// request made by the pinger. The function is called synchronously from the
+// pinger's goroutine and should not block.
+func WithResultHandler(fn func(Result)) PingerOption {
+	return func(p *pinger) {
+		p.onResult = fn
+	}
 }
 
 type Result struct {
+	// Time at which the request was started.
+	Time time.Time
+
 	Name       string
 	Method     string
 	Status     int
 	StatusText string
 	URL        string
 	Latency    int
-	TraceID    string
-}
-
-func init() {
-	inFlightGauge = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "client_in_flight_requests",
-			Help: "A gauge of in-flight requests for the wrapped client.",
-		},
-		[]string{"target"},
-	)
-
-	requestCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "client_api_requests_total",
-			Help: "A counter for requests from the wrapped client.",
-		},
-		[]string{"target", "code", "method"},
-	)
-
-	// dnsLatencyVec uses custom buckets based on expected dns durations.
-	// It has an instance label "event", which is set in the
-	// DNSStart and DNSDonehook functions defined in the
-	// InstrumentTrace struct below.
-	dnsLatencyVec = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "dns_duration_seconds",
-			Help:    "Trace dns latency histogram.",
-			Buckets: []float64{.005, .01, .025, .05},
-		},
-		[]string{"target", "event"},
-	)
-
-	// tlsLatencyVec uses custom buckets based on expected tls durations.
-	// It has an instance label "event", which is set in the
-	// TLSHandshakeStart and TLSHandshakeDone hook functions defined in the
-	// InstrumentTrace struct below.
-	tlsLatencyVec = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "tls_duration_seconds",
-			Help:    "Trace tls latency histogram.",
-			Buckets: []float64{.05, .1, .25, .5},
-		},
-		[]string{"target", "event"},
-	)
 
-	// reqLatencyVec has no labels, making it a zero-dimensional ObserverVec.
-	reqLatencyVec = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "request_duration_seconds",
-			Help:    "A histogram of request latencies.",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"target"},
-	)
+	// TraceID is the ID of the trace recording the request. It is left empty
+	// when the request's span wasn't sampled, since its trace was never
+	// exported.
+	TraceID string
 
-	// Register all of the metrics in the standard registry.
-	prometheus.MustRegister(requestCounter, tlsLatencyVec, dnsLatencyVec, reqLatencyVec, inFlightGauge)
+	// Err is the client error returned for the request, if any. Status is
+	// left at 0 when Err is set.
+	Err error
 }
 
-func NewInstrumentedPinger(target string, tracer trace.Tracer) *pinger {
+// NewInstrumentedPinger creates a pinger that traces every request with tracer
+// and records request metrics in m.
+func NewInstrumentedPinger(target string, tracer trace.Tracer, m *Metrics, opts ...PingerOption) *pinger {
 	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	client.Timeout = 10 * time.Second
 
 	// Wrap the default RoundTripper with middleware.
-	roundTripper := InstrumentRoundTripperInFlight(inFlightGauge, &target,
-		InstrumentRoundTripperCounter(requestCounter, &target,
-			InstrumentRoundTripperDuration(reqLatencyVec, &target, http.DefaultTransport),
+	roundTripper := InstrumentRoundTripperInFlight(m.InFlight, &target,
+		InstrumentRoundTripperCounter(m.Requests, &target,
+			InstrumentRoundTripperDuration(m.RequestLatency, &target, http.DefaultTransport),
 		),
 	)
 
 	// Set the RoundTripper on our client.
 	client.Transport = roundTripper
-	return &pinger{
+	p := &pinger{
 		client: client,
 		tracer: tracer,
 	}
-}
-
-func (p *pinger) Ping(ctx context.Context, t config.Target) {
-	u, err := url.Parse(t.Url)
-	if err != nil {
-		log.Fatalf("unable to parse URL %s", t.Url)
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
+}
 
+// Ping requests u, the parsed URL of the target, in a loop, waiting the
+// target's jittered delay between each request, until ctx is cancelled.
+func (p *pinger) Ping(ctx context.Context, t config.Target, u *url.URL) {
 	req := http.Request{
 		Method: "GET",
 		URL:    u,
@@ -155,8 +119,13 @@ func (p *pinger) Ping(ctx context.Context, t config.Target) {
 			jitter = float64(defaultJitter)
 		}
 
-		time.Sleep(Jitter(delay, jitter))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(Jitter(delay, jitter)):
+		}
 
+		start := time.Now()
 		currCtx, span := p.tracer.Start(ctx, "zombie.ping",
 			trace.WithSpanKind(trace.SpanKindClient),
 			trace.WithAttributes(
@@ -168,10 +137,24 @@ func (p *pinger) Ping(ctx context.Context, t config.Target) {
 
 		span.SetAttributes(semconv.HTTPClientAttributesFromHTTPRequest(&req)...)
 
+		result := Result{
+			Time:   start,
+			Name:   t.Name,
+			Method: req.Method,
+			URL:    t.Url,
+		}
+		if sc := span.SpanContext(); sc.IsValid() && sc.IsSampled() {
+			result.TraceID = sc.TraceID().String()
+		}
+		if result.Name == "" {
+			result.Name = t.Url
+		}
+
 		res, err := p.client.Do(&req)
 		if err != nil {
 			span.RecordError(err)
 			span.SetStatus(codes.Error, fmt.Sprintf("client error: %s", err))
+			result.Err = err
 		} else {
 			// Reading and closing the body is important to ensure that the file
 			// descriptor is not leaked.
@@ -181,11 +164,18 @@ func (p *pinger) Ping(ctx context.Context, t config.Target) {
 			span.SetAttributes(
 				semconv.HTTPAttributesFromHTTPStatusCode(res.StatusCode)...,
 			)
+			result.Status = res.StatusCode
+			result.StatusText = http.StatusText(res.StatusCode)
 		}
 
 		// Because this is an infinite loop, `defer` will only leak spans forever
 		// hence the need to "manually" end each span
 		span.End()
+
+		result.Latency = int(time.Since(start).Milliseconds())
+		if p.onResult != nil {
+			p.onResult(result)
+		}
 	}
 }
 
@@ -208,12 +198,12 @@ func InstrumentRoundTripperCounter(counter *prometheus.CounterVec, target *strin
 	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
 		resp, err := next.RoundTrip(r)
 		if err == nil {
-
-			counter.With(prometheus.Labels{
+			c := counter.With(prometheus.Labels{
 				"code":   fmt.Sprint(resp.StatusCode),
 				"method": r.Method,
 				"target": *target,
-			}).Inc()
+			})
+			promutil.Add(c, 1, promutil.Exemplar(trace.SpanContextFromContext(r.Context())))
 		}
 		return resp, err
 	})
@@ -224,9 +214,10 @@ func InstrumentRoundTripperDuration(obs prometheus.ObserverVec, target *string,
 		start := time.Now()
 		resp, err := next.RoundTrip(r)
 		if err == nil {
-			obs.With(prometheus.Labels{
+			o := obs.With(prometheus.Labels{
 				"target": *target,
-			}).Observe(time.Since(start).Seconds())
+			})
+			promutil.Observe(o, time.Since(start).Seconds(), promutil.Exemplar(trace.SpanContextFromContext(r.Context())))
 		}
 		return resp, err
 	})
@@ -1,14 +1,171 @@
 package client
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/wperron/o11yutil/config"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestJitter(t *testing.T) {
-	v, j := 1.0, 0.2
+	v, j := float64(time.Second), 0.2
 	r := float64(Jitter(v, j))
 
-	if 0.8 > r || r > 1.2 {
-		t.Errorf("expected %f with jitter of %f to be between 0.8 and 0.2, got %f", v, j, r)
+	if 0.8*v > r || r > 1.2*v {
+		t.Errorf("expected %f with jitter of %f to be between %f and %f, got %f", v, j, (1-j)*v, (1+j)*v, r)
+	}
+}
+
+func TestNewMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	opts := MetricsOpts{
+		Namespace:   "zombie",
+		ConstLabels: prometheus.Labels{"env": "test"},
+	}
+
+	m1, err := NewMetrics(reg, opts)
+	if err != nil {
+		t.Fatalf("failed to create metrics: %s", err)
+	}
+
+	m2, err := NewMetrics(reg, opts)
+	if err != nil {
+		t.Fatalf("failed to create metrics a second time on the same registry: %s", err)
+	}
+
+	if m1.Requests != m2.Requests {
+		t.Errorf("expected metrics registered twice to share collectors")
+	}
+
+	m1.Requests.WithLabelValues("foo", "200", "GET").Inc()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() == "zombie_client_api_requests_total" {
+			found = true
+			hasEnv := false
+			for _, l := range mf.GetMetric()[0].GetLabel() {
+				if l.GetName() == "env" && l.GetValue() == "test" {
+					hasEnv = true
+				}
+			}
+			if !hasEnv {
+				t.Errorf("expected const label env=test on %s", mf.GetName())
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected namespaced request counter to be registered")
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg, MetricsOpts{})
+	if err != nil {
+		t.Fatalf("failed to create metrics: %s", err)
+	}
+
+	target := "foo"
+	rt := InstrumentRoundTripperCounter(m.Requests, &target,
+		InstrumentRoundTripperDuration(m.RequestLatency, &target,
+			RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK}, nil
+			}),
+		),
+	)
+
+	traceID := trace.TraceID{0x01, 0x02, 0x03}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	})
+	req := httptest.NewRequest(http.MethodGet, "http://example.org", nil)
+	req = req.WithContext(trace.ContextWithSpanContext(req.Context(), sc))
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("unexpected round trip error: %s", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+
+	for _, mf := range mfs {
+		var exemplars []*dto.Exemplar
+		switch mf.GetName() {
+		case "client_api_requests_total":
+			exemplars = append(exemplars, mf.GetMetric()[0].GetCounter().GetExemplar())
+		case "request_duration_seconds":
+			for _, b := range mf.GetMetric()[0].GetHistogram().GetBucket() {
+				if b.GetExemplar() != nil {
+					exemplars = append(exemplars, b.GetExemplar())
+				}
+			}
+		default:
+			continue
+		}
+
+		if len(exemplars) != 1 || exemplars[0] == nil {
+			t.Errorf("expected one exemplar on %s, got %d", mf.GetName(), len(exemplars))
+			continue
+		}
+		if l := exemplars[0].GetLabel()[0]; l.GetName() != "traceID" || l.GetValue() != traceID.String() {
+			t.Errorf("expected traceID exemplar on %s, got %s=%s", mf.GetName(), l.GetName(), l.GetValue())
+		}
+	}
+}
+
+func TestPingTraceID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("failed to parse URL: %s", err)
+	}
+
+	cases := map[string]struct {
+		sampler sdktrace.Sampler
+		traced  bool
+	}{
+		"sampled":     {sdktrace.AlwaysSample(), true},
+		"not sampled": {sdktrace.NeverSample(), false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(c.sampler))
+			m, err := NewMetrics(prometheus.NewRegistry(), MetricsOpts{})
+			if err != nil {
+				t.Fatalf("failed to create metrics: %s", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var result Result
+			p := NewInstrumentedPinger("foo", tp.Tracer("test"), m, WithResultHandler(func(r Result) {
+				result = r
+				cancel()
+			}))
+			p.Ping(ctx, config.Target{Url: srv.URL, Delay: 1}, u)
+
+			if traced := result.TraceID != ""; traced != c.traced {
+				t.Errorf("expected traced=%t, got trace ID %q", c.traced, result.TraceID)
+			}
+		})
 	}
 }
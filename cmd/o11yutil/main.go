@@ -0,0 +1,88 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Command o11yutil runs a minimal OTLP trace receiver for local debugging.
+// It prints the received spans with the debugprocessor, and serves a trace
+// listing and search API, so that trace-server and zombie can be inspected
+// without running a tracing backend:
+//
+//	o11yutil &
+//	trace-server -trace=localhost:4317
+//	curl 'localhost:4318/api/traces?service=trace-server&minDuration=500ms'
+//	curl 'localhost:4318/api/traces/{traceID}?format=text'
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/wperron/o11yutil/debugprocessor"
+	"github.com/wperron/o11yutil/receiver"
+	"go.opentelemetry.io/otel"
+)
+
+var (
+	grpcAddr    = flag.String("grpc-addr", "localhost:4317", "Address the OTLP gRPC receiver will listen on.")
+	httpAddr    = flag.String("http-addr", "localhost:4318", "Address the OTLP/HTTP receiver and the trace API will listen on.")
+	maxTraces   = flag.Int("max-traces", 1000, "Number of traces kept in memory for the trace API.")
+	format      = flag.String("format", "text", "Format received spans are printed in: text, json or logfmt.")
+	quiet       = flag.Bool("quiet", false, "Don't print received spans.")
+	ids         = flag.Bool("ids", false, "Print the trace and span IDs of the received spans.")
+	minDuration = flag.Duration("min-duration", 0, "Only print spans lasting at least this long.")
+	errorsOnly  = flag.Bool("errors-only", false, "Only print spans with an error status.")
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	flag.Parse()
+	logger := log.With(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)), "ts", log.DefaultTimestampUTC)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		_ = logger.Log("msg", "opentelemetry error", "err", err)
+	}))
+
+	opts := []receiver.Option{receiver.WithMaxTraces(*maxTraces)}
+	if !*quiet {
+		encoding, err := debugprocessor.ParseEncoding(*format)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, receiver.WithExporter(debugprocessor.New(
+			debugprocessor.WithWriter(os.Stdout),
+			debugprocessor.WithEncoding(encoding),
+			debugprocessor.WithIDs(*ids),
+			debugprocessor.WithResource(true),
+			debugprocessor.WithMinDuration(*minDuration),
+			debugprocessor.WithErrorsOnly(*errorsOnly),
+		).BuildExporter()))
+	}
+	r := receiver.New(opts...)
+
+	// Print the spans still buffered before exiting
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.Shutdown(shutCtx); err != nil {
+			_ = logger.Log("msg", "failed to shut down", "err", err)
+		}
+	}()
+
+	_ = logger.Log("msg", "listening", "grpc", *grpcAddr, "http", *httpAddr)
+	if err := r.ListenAndServe(*grpcAddr, *httpAddr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		_ = logger.Log("msg", "failed to serve", "err", err)
+		os.Exit(1)
+	}
+	<-stopped
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Command replay ships the spans recorded to an OTLP file by zombie or
+// trace-server to an OTLP endpoint, so that runs without a collector can be
+// analyzed after the fact.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/wperron/o11yutil/internal/keyvalue"
+	"github.com/wperron/o11yutil/otlpfile"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+)
+
+var (
+	filePath = flag.String("file", "", "The location of the OTLP file to replay.")
+	format   = flag.String("format", "", "Encoding of the file: json or proto. Defaults to json for .json and .jsonl files, proto otherwise.")
+	endpoint = flag.String("endpoint", "localhost:4317", "Address of the OTLP endpoint.")
+	protocol = flag.String("protocol", "grpc", "Protocol used to send spans: grpc or http.")
+	insecure = flag.Bool("insecure", true, "Send spans without TLS.")
+	headers  = flag.String("headers", "", "Comma-separated list of key=value headers sent with the spans.")
+	retime   = flag.Bool("retime", false, "Move spans to the current time, keeping the time between them. The earliest span of the file starts now.")
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	flag.Parse()
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
+
+	if err := run(ctx, logger); err != nil {
+		_ = logger.Log("msg", "failed to replay spans", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, logger log.Logger) error {
+	if *filePath == "" {
+		return errors.New("missing -file")
+	}
+	f, err := otlpfile.ParseFormat(*format, *filePath)
+	if err != nil {
+		return err
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*filePath)
+	if err != nil {
+		return fmt.Errorf("reading file %s: %s", *filePath, err)
+	}
+	defer file.Close()
+
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("connecting to %s: %s", *endpoint, err)
+	}
+	defer client.Stop(context.Background()) // nolint
+
+	// Batches are ordered by the end of their spans, so long spans of later
+	// batches may start before every span of the first one.
+	var offset time.Duration
+	if *retime {
+		earliest, err := earliest(otlpfile.NewReader(file, f))
+		if err != nil {
+			return err
+		}
+		offset = time.Since(earliest)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewinding file %s: %s", *filePath, err)
+		}
+	}
+
+	var (
+		r       = otlpfile.NewReader(file, f)
+		batches int
+		spans   int
+	)
+	for {
+		req, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if *retime {
+			otlpfile.Shift(req, offset)
+		}
+
+		if err := client.UploadTraces(ctx, req.ResourceSpans); err != nil {
+			return fmt.Errorf("sending spans: %s", err)
+		}
+		batches++
+		for _, rs := range req.ResourceSpans {
+			for _, ils := range rs.InstrumentationLibrarySpans {
+				spans += len(ils.Spans)
+			}
+		}
+	}
+
+	_ = logger.Log("msg", "replayed spans", "file", *filePath, "endpoint", *endpoint, "batches", batches, "spans", spans)
+	return nil
+}
+
+// earliest returns the start time of the earliest span read from r.
+func earliest(r *otlpfile.Reader) (time.Time, error) {
+	var min time.Time
+	for {
+		req, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return min, nil
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t := otlpfile.Earliest(req); !t.IsZero() && (min.IsZero() || t.Before(min)) {
+			min = t
+		}
+	}
+}
+
+func newClient() (otlptrace.Client, error) {
+	h, err := keyvalue.Parse(*headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid headers: %s", err)
+	}
+
+	switch *protocol {
+	case "grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(*endpoint), otlptracegrpc.WithHeaders(h)}
+		if *insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.NewClient(opts...), nil
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(*endpoint), otlptracehttp.WithHeaders(h)}
+		if *insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.NewClient(opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", *protocol)
+	}
+}
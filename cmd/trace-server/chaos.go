@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wperron/o11yutil/chaos"
+)
+
+var (
+	chaosConfigPath = flag.String("chaos", "", "The location of the fault injection config file. Flags take precedence over the file.")
+	errorPercent    = flag.Float64("error-percent", 0, "Percentage of requests answered with a server error.")
+	errorCodes      = flag.String("error-codes", "500", "Comma-separated list of status codes used for injected errors.")
+	latencyDist     = flag.String("latency-dist", "", "Distribution of the latency added to requests: fixed, uniform, normal or exponential.")
+	latencyPercent  = flag.Float64("latency-percent", 100, "Percentage of requests with added latency.")
+	latencyMin      = flag.Int64("latency-min", 0, "Minimum added latency in milliseconds, for the uniform distribution.")
+	latencyMax      = flag.Int64("latency-max", 0, "Maximum added latency in milliseconds, for the uniform distribution.")
+	latencyMean     = flag.Int64("latency-mean", 0, "Mean added latency in milliseconds, for the fixed, normal and exponential distributions.")
+	latencyStdDev   = flag.Int64("latency-stddev", 0, "Standard deviation of the added latency in milliseconds, for the normal distribution.")
+	hangPercent     = flag.Float64("hang-percent", 0, "Percentage of requests held without an answer.")
+	hangDuration    = flag.Int64("hang-duration", 0, "How long to hold hanging requests in milliseconds. Defaults to until the client gives up.")
+	resetPercent    = flag.Float64("reset-percent", 0, "Percentage of connections reset without an answer.")
+	panicPercent    = flag.Float64("panic-percent", 0, "Percentage of requests making the handler panic.")
+	slowBodyPercent = flag.Float64("slow-body-percent", 0, "Percentage of responses streamed one byte at a time.")
+	slowBodyDelay   = flag.Int64("slow-body-delay", 100, "Delay between each byte of slow responses in milliseconds.")
+	chaosHeaders    = flag.Bool("chaos-headers", false, "Allow forcing faults per request with the X-Chaos-* headers. Any client can then make the server fail.")
+)
+
+// chaosConfig builds the fault injection config from the config file, if
+// any, overridden by the flags explicitly set on the command line.
+func chaosConfig() (*chaos.Config, error) {
+	cfg := &chaos.Config{}
+	if *chaosConfigPath != "" {
+		var err error
+		if cfg, err = chaos.LoadFile(*chaosConfigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "error-percent":
+			cfg.ErrorPercent = *errorPercent
+		case "error-codes":
+			cfg.ErrorCodes = nil
+			for _, s := range strings.Split(*errorCodes, ",") {
+				code, convErr := strconv.Atoi(strings.TrimSpace(s))
+				if convErr != nil {
+					err = fmt.Errorf("invalid error code %q", s)
+					return
+				}
+				cfg.ErrorCodes = append(cfg.ErrorCodes, code)
+			}
+		case "latency-dist", "latency-percent", "latency-min", "latency-max", "latency-mean", "latency-stddev":
+			if cfg.Latency == nil {
+				cfg.Latency = &chaos.Latency{}
+			}
+			switch f.Name {
+			case "latency-dist":
+				cfg.Latency.Duration.Distribution = *latencyDist
+			case "latency-percent":
+				cfg.Latency.Percent = latencyPercent
+			case "latency-min":
+				cfg.Latency.Duration.Min = *latencyMin
+			case "latency-max":
+				cfg.Latency.Duration.Max = *latencyMax
+			case "latency-mean":
+				cfg.Latency.Duration.Mean = *latencyMean
+			case "latency-stddev":
+				cfg.Latency.Duration.StdDev = *latencyStdDev
+			}
+		case "hang-percent":
+			cfg.HangPercent = *hangPercent
+		case "hang-duration":
+			cfg.HangDuration = *hangDuration
+		case "reset-percent":
+			cfg.ResetPercent = *resetPercent
+		case "panic-percent":
+			cfg.PanicPercent = *panicPercent
+		case "slow-body-percent":
+			cfg.SlowBodyPercent = *slowBodyPercent
+		case "slow-body-delay":
+			cfg.SlowBodyDelay = *slowBodyDelay
+		case "chaos-headers":
+			cfg.Headers = *chaosHeaders
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid fault injection config: %w", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/wperron/o11yutil/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	format        = flag.String("format", "logfmt", "Log output format. Defaults to 'logfmt'")
+	logSpanEvents = flag.Bool("log-span-events", false, "Also record log lines as events on the current span.")
+)
+
+var logger = log.NewNopLogger()
+
+// initLogging sets up the logger, and redirects the standard library and
+// OpenTelemetry logs to it.
+func initLogging() error {
+	l, err := logging.New(*format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	logger = log.With(l, "ts", log.DefaultTimestampUTC)
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.NewStdlibAdapter(logger))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		_ = logger.Log("msg", "opentelemetry error", "err", err)
+	}))
+	return nil
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	_ = logger.Log("msg", msg, "err", err)
+	os.Exit(1)
+}
+
+// loggerFromContext returns a logger adding the IDs of the span in ctx to
+// every line, so that logs can be correlated with traces.
+func loggerFromContext(ctx context.Context) log.Logger {
+	return spanLogger{ctx: ctx, next: logger}
+}
+
+// spanLogger prefixes log lines with the trace and span IDs of the span in
+// its context and, with -log-span-events, records them as span events.
+type spanLogger struct {
+	ctx  context.Context
+	next log.Logger
+}
+
+func (l spanLogger) Log(keyvals ...interface{}) error {
+	span := trace.SpanFromContext(l.ctx)
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return l.next.Log(keyvals...)
+	}
+
+	if *logSpanEvents && span.IsRecording() {
+		name, attrs := spanEvent(keyvals)
+		span.AddEvent(name, trace.WithAttributes(attrs...))
+	}
+
+	kvs := make([]interface{}, 0, len(keyvals)+4)
+	kvs = append(kvs, "traceID", sc.TraceID().String(), "spanID", sc.SpanID().String())
+	kvs = append(kvs, keyvals...)
+	return l.next.Log(kvs...)
+}
+
+// spanEvent converts a log line to a span event, named after its message.
+func spanEvent(keyvals []interface{}) (string, []attribute.KeyValue) {
+	name := "log"
+	attrs := make([]attribute.KeyValue, 0, len(keyvals)/2)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		k := fmt.Sprint(keyvals[i])
+		if k == "msg" {
+			name = fmt.Sprint(keyvals[i+1])
+			continue
+		}
+		attrs = append(attrs, attribute.String(k, fmt.Sprint(keyvals[i+1])))
+	}
+	return name, attrs
+}
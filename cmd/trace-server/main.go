@@ -2,36 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/wperron/o11yutil/debugprocessor"
+	"github.com/wperron/o11yutil/chaos"
+	"github.com/wperron/o11yutil/mesh"
+	"github.com/wperron/o11yutil/middleware"
+	"github.com/wperron/o11yutil/topology"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/codes"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 	"go.opentelemetry.io/otel/trace"
-	"google.golang.org/grpc"
 )
 
 var (
-	addr          = flag.String("addr", "", "Address the api will listen on.")
-	traceEndpoint = flag.String("trace", "", "Address for the OpenTelemetry Collector.")
-	tracer        trace.Tracer
-	latency       prometheus.Histogram
+	addr               = flag.String("addr", "", "Address the api will listen on.")
+	topologyPath       = flag.String("topology", "", "The location of a topology file describing the traces to generate. Defaults to a random chain of spans.")
+	servicesPath       = flag.String("services", "", "The location of a file describing virtual services to run alongside the server.")
+	durationBuckets    = flag.String("duration-buckets", "", "Comma-separated list of request duration buckets in seconds. Defaults to the Prometheus default buckets.")
+	sizeBuckets        = flag.String("size-buckets", "", "Comma-separated list of request and response size buckets in bytes. Defaults to powers of 4 from 64B to 4MiB.")
+	instrumentInternal = flag.Bool("instrument-internal", false, "Trace and measure the /metrics and /healthz endpoints too.")
+	tracer             trace.Tracer
 )
 
 func main() {
@@ -42,76 +47,81 @@ func main() {
 
 	flag.Parse()
 
-	// Setup tracing
-	exp, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithInsecure(),
-		otlptracegrpc.WithEndpoint(*traceEndpoint),
-		// TODO(wperron) replace grpc.WithTimeout, deprecated
-		otlptracegrpc.WithDialOption(grpc.WithBlock(), grpc.WithTimeout(5*time.Second)), // nolint
-	)
-	if err != nil {
-		log.Fatalf("failed to create trace exporter: %s", err)
+	if err := initLogging(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			// the service name used to display traces in backends
-			semconv.ServiceNameKey.String("trace-server"),
-		),
-	)
+	chaosConf, err := chaosConfig()
 	if err != nil {
-		log.Fatalf("failed to create trace resource: %s", err)
+		fatal("invalid fault injection config", err)
 	}
 
-	// Test debug span processor
-	debug := debugprocessor.New().WithWriter(os.Stdout).Build()
+	// Setup tracing
+	tracingConf, err := tracingConfig()
+	if err != nil {
+		fatal("invalid tracing config", err)
+	}
 
-	bsp := sdktrace.NewBatchSpanProcessor(exp)
-	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithResource(res),
-		sdktrace.WithSpanProcessor(debug),
-		sdktrace.WithSpanProcessor(bsp),
-	)
+	tracerProvider, err := tracingConf.newTracerProvider(ctx, "trace-server")
+	if err != nil {
+		fatal("failed to create tracer provider", err)
+	}
 
-	// set global propagator to tracecontext (the default is no-op).
-	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// set the global propagator (the default is no-op).
+	otel.SetTextMapPropagator(tracingConf.propagator)
 	otel.SetTracerProvider(tracerProvider)
 
-	defer tracerProvider.Shutdown(ctx) // nolint
+	// Tracer providers of the server and the virtual services, shut down once
+	// the server stops.
+	providers := []*sdktrace.TracerProvider{tracerProvider}
 
 	tracer = otel.Tracer("trace-server")
 
-	// Create and register basic prometheus metrics for the API's usage
-	counter := prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "api_requests_total",
-			Help: "A counter for requests to the api.",
-		},
-		[]string{"code", "method"},
-	)
-
-	latency = prometheus.NewHistogram(
-		prometheus.HistogramOpts{
-			Name: "api_requests_latency",
-			Help: "A histogram for api response latencies.",
-		},
-	)
+	h := new(handler)
+	if *topologyPath != "" {
+		topo, err := topology.LoadFile(*topologyPath)
+		if err != nil {
+			fatal("failed to load topology", err)
+		}
+		if h.generator, err = topology.NewGenerator(topo, tracer); err != nil {
+			fatal("failed to create trace generator", err)
+		}
+	}
 
-	inFlight := prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: "api_requests_in_flight",
-			Help: "A gauge for the number of in-flight requests.",
-		},
-	)
+	durations, err := parseBuckets(*durationBuckets)
+	if err != nil {
+		fatal("invalid duration buckets", err)
+	}
+	sizes, err := parseBuckets(*sizeBuckets)
+	if err != nil {
+		fatal("invalid size buckets", err)
+	}
 
-	prometheus.MustRegister(counter, latency, inFlight)
+	// Create and register RED metrics for the API's usage
+	metrics, err := middleware.NewMetrics(prometheus.DefaultRegisterer, middleware.MetricsOpts{
+		DurationBuckets: durations,
+		SizeBuckets:     sizes,
+	})
+	if err != nil {
+		fatal("failed to create metrics", err)
+	}
+	instrument := func(route string, h http.Handler) http.Handler {
+		return InstrumentedHandler(route, metrics, h)
+	}
+	internal := func(route string, h http.Handler) http.Handler {
+		if *instrumentInternal {
+			return instrument(route, h)
+		}
+		return h
+	}
 
-	http.Handle("/", promhttp.InstrumentHandlerCounter(
-		counter, promhttp.InstrumentHandlerInFlight(inFlight, InstrumentedHandler(new(handler))),
-	))
+	// Faults are injected inside the instrumentation so that they show up in
+	// traces and metrics.
+	injector := chaos.New(*chaosConf)
+	http.Handle("/", instrument("/", injector.Handler(h)))
 
-	http.Handle("/metrics", InstrumentedHandler(promhttp.HandlerFor(
+	http.Handle("/metrics", internal("/metrics", promhttp.HandlerFor(
 		prometheus.DefaultGatherer,
 		promhttp.HandlerOpts{
 			// Opt into OpenMetrics to support exemplars
@@ -119,18 +129,90 @@ func main() {
 		},
 	)))
 
-	log.Printf("listening on %s", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
-		log.Fatal(err)
+	http.Handle("/healthz", internal("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})))
+
+	// Start the virtual services, each with its own tracer provider
+	var m *mesh.Mesh
+	if *servicesPath != "" {
+		conf, err := mesh.LoadFile(*servicesPath)
+		if err != nil {
+			fatal("failed to load services", err)
+		}
+
+		m, err = mesh.New(conf, func(name string) (trace.TracerProvider, error) {
+			tp, err := tracingConf.newTracerProvider(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, tp)
+			return tp, nil
+		}, tracingConf.propagator)
+		if err != nil {
+			fatal("failed to create services", err)
+		}
+
+		go func() {
+			if err := m.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("failed to serve services", err)
+			}
+		}()
+		for _, svc := range conf.Services {
+			_ = logger.Log("msg", "service listening", "service", svc.Name, "addr", svc.Addr)
+		}
+	}
+
+	srv := &http.Server{Addr: *addr}
+	go func() {
+		<-ctx.Done()
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutCtx)
+		if m != nil {
+			_ = m.Shutdown(shutCtx)
+		}
+	}()
+
+	_ = logger.Log("msg", "listening", "addr", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("failed to serve", err)
+	}
+
+	// Flush the last spans, with a context of their own since ctx is done.
+	_ = logger.Log("msg", "shutting down")
+	shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tp := range providers {
+		if err := tp.Shutdown(shutCtx); err != nil {
+			_ = logger.Log("msg", "failed to stop tracer provider", "err", err)
+		}
+	}
+	if err := tracingConf.shutdown(shutCtx); err != nil {
+		_ = logger.Log("msg", "failed to stop tracing", "err", err)
 	}
 }
 
-type handler struct{}
+type handler struct {
+	// generator produces the spans of each request following a topology.
+	// randomRecurse is used instead when nil.
+	generator *topology.Generator
+}
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, span := tracer.Start(r.Context(), "handler")
 	defer span.End()
-	randomRecurse(ctx, 0, 10, int(200*time.Millisecond), int(1000*time.Millisecond))
+
+	if h.generator == nil {
+		randomRecurse(ctx, 0, 10, int(200*time.Millisecond), int(1000*time.Millisecond))
+	} else if err := h.generator.Run(ctx); err != nil {
+		_ = loggerFromContext(ctx).Log("msg", "failed to generate trace", "err", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	fmt.Fprint(w, "Hello, World!")
 }
 
@@ -142,6 +224,7 @@ func randomRecurse(ctx context.Context, curr, max, minDur, maxDur int) {
 	))
 	defer span.End()
 
+	_ = loggerFromContext(ctx).Log("msg", "recursing", "depth", curr, "duration", dur)
 	time.Sleep(dur)
 	if curr == max {
 		return
@@ -153,53 +236,38 @@ func randomRecurse(ctx context.Context, curr, max, minDur, maxDur int) {
 	}
 }
 
-func InstrumentedHandler(next http.Handler) http.Handler {
-	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		d := newDelegator(w)
-		ctx := r.Context()
-		traceID := trace.SpanContextFromContext(ctx).TraceID().String()
-		next.ServeHTTP(d, r)
-		latency.(prometheus.ExemplarObserver).ObserveWithExemplar(
-			time.Since(start).Seconds(), prometheus.Labels{"traceID": traceID},
-		)
-		fmt.Printf("traceID=%s path=%s method=%s status=%d bytes=%d\n", traceID, r.URL.Path, r.Method, d.statusCode, d.written)
-	}
-
-	otelHandler := otelhttp.NewHandler(http.HandlerFunc(handlerFunc), "http")
-
-	return otelHandler
-}
-
-type responseWriterDelegator struct {
-	http.ResponseWriter
-	statusCode  int
-	written     int64
-	wroteHeader bool
-}
+// InstrumentedHandler traces next, measures it with metrics and logs every
+// request. The route is the pattern next is registered with.
+func InstrumentedHandler(route string, metrics *middleware.Metrics, next http.Handler) http.Handler {
+	logged := middleware.Record(next, func(r *http.Request, rec *middleware.Recorder) {
+		_ = loggerFromContext(r.Context()).Log("msg", "request", "route", route, "path", r.URL.Path, "method", r.Method, "status", rec.Status, "bytes", rec.Written, "duration", rec.Duration())
+	})
 
-func (d *responseWriterDelegator) WriteHeader(statusCode int) {
-	d.statusCode = statusCode
-}
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRouteKey.String(route))
+		logged.ServeHTTP(w, r)
+	})
 
-func (d *responseWriterDelegator) Write(b []byte) (int, error) {
-	if !d.wroteHeader {
-		d.WriteHeader(http.StatusOK)
-	}
-	n, err := d.ResponseWriter.Write(b)
-	d.written += int64(n)
-	return n, err
+	return otelhttp.NewHandler(metrics.Handler(route, routed), route)
 }
 
-func (d *responseWriterDelegator) Flush() {
-	if !d.wroteHeader {
-		d.WriteHeader(http.StatusOK)
+// parseBuckets parses a comma-separated list of histogram buckets, returning
+// nil for the default buckets when s is empty.
+func parseBuckets(s string) ([]float64, error) {
+	if s == "" {
+		return nil, nil
 	}
-	d.ResponseWriter.(http.Flusher).Flush()
-}
 
-func newDelegator(w http.ResponseWriter) *responseWriterDelegator {
-	return &responseWriterDelegator{
-		ResponseWriter: w,
+	var buckets []float64
+	for _, b := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", b)
+		}
+		if len(buckets) > 0 && f <= buckets[len(buckets)-1] {
+			return nil, errors.New("buckets must be in increasing order")
+		}
+		buckets = append(buckets, f)
 	}
+	return buckets, nil
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/debugprocessor"
+	"github.com/wperron/o11yutil/internal/keyvalue"
+	"github.com/wperron/o11yutil/otlpfile"
+	"github.com/wperron/o11yutil/spanmetrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	traceEndpoint      = flag.String("trace", "", "Address for the OpenTelemetry Collector.")
+	traceExporter      = flag.String("trace-exporter", "grpc", "Protocol used to export spans: grpc, http, stdout, file or none.")
+	traceInsecure      = flag.Bool("trace-insecure", true, "Export spans without TLS.")
+	traceCAFile        = flag.String("trace-ca-file", "", "CA certificate used to verify the collector, instead of the system pool.")
+	traceCertFile      = flag.String("trace-cert-file", "", "Client certificate presented to the collector.")
+	traceKeyFile       = flag.String("trace-key-file", "", "Key of the client certificate presented to the collector.")
+	traceHeaders       = flag.String("trace-headers", "", "Comma-separated list of key=value headers sent with exported spans.")
+	traceFile          = flag.String("trace-file", "spans.pb", "The location of the OTLP file spans are recorded to with -trace-exporter=file.")
+	traceFileFormat    = flag.String("trace-file-format", "", "Encoding of the OTLP file: json or proto. Defaults to json for .json and .jsonl files, proto otherwise.")
+	traceRetry         = flag.Int64("trace-retry", 60000, "How long to retry failed exports in milliseconds, 0 disables retries.")
+	sampler            = flag.String("sampler", "parentbased_always_on", "Sampler: always_on, always_off, traceidratio, parentbased_always_on, parentbased_always_off or parentbased_traceidratio.")
+	samplerRatio       = flag.Float64("sampler-ratio", 1, "Ratio of traces sampled by the traceidratio samplers, between 0 and 1.")
+	propagators        = flag.String("propagators", "tracecontext,baggage", "Comma-separated list of propagators: tracecontext, baggage or none.")
+	resourceAttributes = flag.String("resource-attributes", "", "Comma-separated list of key=value resource attributes added to every service.")
+	spanMetrics        = flag.Bool("span-metrics", true, "Derive RED and service graph metrics from the spans of every service.")
+)
+
+// tracing holds the tracing settings shared by the server and the virtual
+// services, validated once at startup.
+type tracing struct {
+	sampler    sdktrace.Sampler
+	propagator propagation.TextMapPropagator
+	headers    map[string]string
+	attributes []attribute.KeyValue
+	tls        *tls.Config
+
+	// spanMetrics is shared by every service so that calls between them are
+	// paired into service graph edges.
+	spanMetrics *spanmetrics.Processor
+
+	// file records the spans of every service with -trace-exporter=file.
+	file *otlptrace.Exporter
+}
+
+// tracingConfig builds the tracing settings from the command line flags.
+func tracingConfig() (*tracing, error) {
+	t := &tracing{}
+
+	switch *traceExporter {
+	case "grpc", "http", "stdout", "file", "none":
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", *traceExporter)
+	}
+
+	var err error
+	if t.sampler, err = newSampler(*sampler, *samplerRatio); err != nil {
+		return nil, err
+	}
+	if t.propagator, err = newPropagator(*propagators); err != nil {
+		return nil, err
+	}
+	if t.headers, err = keyvalue.Parse(*traceHeaders); err != nil {
+		return nil, fmt.Errorf("invalid trace headers: %s", err)
+	}
+
+	attrs, err := keyvalue.Parse(*resourceAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource attributes: %s", err)
+	}
+	for k, v := range attrs {
+		t.attributes = append(t.attributes, attribute.String(k, v))
+	}
+
+	if !*traceInsecure {
+		if t.tls, err = clientTLS(*traceCAFile, *traceCertFile, *traceKeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if *spanMetrics {
+		if t.spanMetrics, err = spanmetrics.New(prometheus.DefaultRegisterer, spanmetrics.Opts{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if *traceExporter == "file" {
+		f, err := otlpfile.ParseFormat(*traceFileFormat, *traceFile)
+		if err != nil {
+			return nil, err
+		}
+		if t.file, err = otlpfile.Create(*traceFile, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// shutdown closes the exporters shared by the tracer providers, once they
+// are all shut down.
+func (t *tracing) shutdown(ctx context.Context) error {
+	if t.file != nil {
+		return t.file.Shutdown(ctx)
+	}
+	return nil
+}
+
+// newTracerProvider creates a TracerProvider exporting the spans of the named
+// service. The exporter connects in the background, so that the server starts
+// even when the collector is not reachable yet.
+func (t *tracing) newTracerProvider(ctx context.Context, service string) (*sdktrace.TracerProvider, error) {
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithAttributes(t.attributes...),
+		resource.WithAttributes(
+			// the service name used to display traces in backends
+			semconv.ServiceNameKey.String(service),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %s", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(t.sampler),
+		sdktrace.WithResource(res),
+	}
+
+	if t.spanMetrics != nil {
+		opts = append(opts, sdktrace.WithSpanProcessor(t.spanMetrics))
+	}
+
+	switch *traceExporter {
+	case "grpc":
+		exp, err := otlptracegrpc.New(ctx, t.grpcOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace exporter: %s", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "http":
+		exp, err := otlptracehttp.New(ctx, t.httpOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace exporter: %s", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		// Print spans in the same format as the logs, with the resource to
+		// tell the virtual services apart
+		encoding, err := debugprocessor.ParseEncoding(*format)
+		if err != nil {
+			return nil, err
+		}
+		debug := debugprocessor.New().WithWriter(os.Stdout).WithEncoding(encoding).WithResource(true).WithRegisterer(prometheus.DefaultRegisterer).Build()
+		opts = append(opts, sdktrace.WithSpanProcessor(debug))
+	case "file":
+		opts = append(opts, sdktrace.WithBatcher(sharedExporter{t.file}))
+	}
+
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// sharedExporter keeps an exporter shared by several tracer providers open
+// when one of them shuts down.
+type sharedExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (sharedExporter) Shutdown(context.Context) error { return nil }
+
+func (t *tracing) grpcOptions() []otlptracegrpc.Option {
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(*traceEndpoint),
+		otlptracegrpc.WithReconnectionPeriod(5 * time.Second),
+		otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig{
+			Enabled:         *traceRetry > 0,
+			InitialInterval: 5 * time.Second,
+			MaxInterval:     30 * time.Second,
+			MaxElapsedTime:  time.Duration(*traceRetry) * time.Millisecond,
+		}),
+	}
+	if t.tls == nil {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	} else {
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(t.tls)))
+	}
+	if len(t.headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(t.headers))
+	}
+	return opts
+}
+
+func (t *tracing) httpOptions() []otlptracehttp.Option {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(*traceEndpoint),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{
+			Enabled:         *traceRetry > 0,
+			InitialInterval: 5 * time.Second,
+			MaxInterval:     30 * time.Second,
+			MaxElapsedTime:  time.Duration(*traceRetry) * time.Millisecond,
+		}),
+	}
+	if t.tls == nil {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	} else {
+		opts = append(opts, otlptracehttp.WithTLSClientConfig(t.tls))
+	}
+	if len(t.headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(t.headers))
+	}
+	return opts
+}
+
+// newSampler returns the sampler with the given name, using the same names as
+// the OTEL_TRACES_SAMPLER environment variable.
+func newSampler(name string, ratio float64) (sdktrace.Sampler, error) {
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sampler ratio must be between 0 and 1")
+	}
+
+	switch name {
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unknown sampler %q", name)
+	}
+}
+
+// newPropagator returns a composite of the comma-separated propagators.
+func newPropagator(names string) (propagation.TextMapPropagator, error) {
+	var props []propagation.TextMapPropagator
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "tracecontext":
+			props = append(props, propagation.TraceContext{})
+		case "baggage":
+			props = append(props, propagation.Baggage{})
+		case "none", "":
+		default:
+			return nil, fmt.Errorf("unknown propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), nil
+}
+
+// clientTLS creates the TLS config used to connect to the collector.
+func clientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading file %s: %s", caFile, err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %s", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+}
@@ -7,20 +7,26 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/wperron/o11yutil/api"
 	"github.com/wperron/o11yutil/client"
 	"github.com/wperron/o11yutil/config"
 	"github.com/wperron/o11yutil/debugprocessor"
+	"github.com/wperron/o11yutil/logging"
+	"github.com/wperron/o11yutil/otlpfile"
+	"github.com/wperron/o11yutil/push"
+	"github.com/wperron/o11yutil/runner"
+	"github.com/wperron/o11yutil/spanmetrics"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
@@ -34,12 +40,19 @@ var (
 	Branch   string
 	Revision string
 	logger   log.Logger
-	tracer   trace.Tracer
 )
 
 var (
 	configPath = flag.String("config", "", "The location of the config file.")
 	format     = flag.String("format", "logfmt", "Log output format. Defaults to 'logfmt'")
+
+	journalPath       = flag.String("trace-journal", "", "The location of a file to also print traces to, as trees of spans.")
+	journalMaxSize    = flag.Int64("trace-journal-max-size", 100<<20, "Size in bytes past which the trace journal is rotated, 0 disables size-based rotation.")
+	journalMaxAge     = flag.Duration("trace-journal-max-age", 24*time.Hour, "How long the trace journal is written to before being rotated, 0 disables age-based rotation.")
+	journalMaxBackups = flag.Int("trace-journal-max-backups", 7, "Number of rotated trace journals kept, 0 keeps them all.")
+
+	traceFile       = flag.String("trace-file", "", "The location of a file to record spans to in the OTLP format, to be replayed later on.")
+	traceFileFormat = flag.String("trace-file-format", "", "Encoding of the OTLP file: json or proto. Defaults to json for .json and .jsonl files, proto otherwise.")
 	// TODO(wperron) add verbose and quiet options
 )
 
@@ -68,100 +81,251 @@ func main() {
 
 	printSummary(*conf)
 
-	logger, err = makeLogger(*format, os.Stdout)
+	logger, err = logging.New(*format, os.Stdout)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// Start the API if enabled
-	if conf.Api != nil && conf.Api.Enabled {
-		go func() {
-			if err := logger.Log(api.Serve(conf.Api.Addr)); err != nil {
-				fmt.Println("error serving api:", err)
-			}
-		}()
-	}
+	// Use a dedicated registry rather than the global one so that only
+	// zombie's own metrics are exposed.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
 
 	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
 
-	shut, err := initTracing(ctx)
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String("zombie"),
+		),
+	)
+	if err != nil {
+		fmt.Println(fmt.Errorf("creating otel resource: %v", err))
+		os.Exit(1)
+	}
+
+	shut, err := initTracing(res, reg)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 	defer shut() // nolint
-	tracer = otel.Tracer("zombie")
 
-	for _, t := range conf.Targets {
-		ns := t.Name
-		if ns == "" {
-			ns = t.Url
-		}
+	r, err := runner.New(*conf,
+		runner.WithTracerProvider(otel.GetTracerProvider()),
+		runner.WithRegisterer(reg),
+		runner.WithLogger(logger),
+	)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-		workers := t.Workers
-		if workers <= 0 {
-			workers = 1
+	// Push metrics if enabled. The pusher gets its own context so that a
+	// last push happens only once every worker has stopped.
+	pushCtx, stopPush := context.WithCancel(context.Background())
+	pushed := make(chan struct{})
+	if conf.Metrics != nil {
+		pusher, err := initPush(pushCtx, conf.Metrics, reg, res)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		go func() {
+			pusher.Run(pushCtx)
+			close(pushed)
+		}()
+	} else {
+		close(pushed)
+	}
 
-		for i := 0; i < workers; i++ {
-			pinger := client.NewInstrumentedPinger(ns, tracer)
-			go pinger.Ping(ctx, t)
+	// Start the API if enabled
+	var srv *api.Server
+	if conf.Api != nil && conf.Api.Enabled {
+		opts := []api.Option{
+			api.WithTraceURL(conf.Api.TraceURL),
+			api.WithAuth(conf.Api.Auth),
+		}
+		if conf.Api.TLS != nil {
+			tlsConfig, err := api.TLSConfig(conf.Api.TLS)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			opts = append(opts, api.WithTLS(tlsConfig))
 		}
+
+		srv = api.New(r, reg, api.BuildInfo{
+			Version:  Version,
+			Branch:   Branch,
+			Revision: Revision,
+		}, opts...)
+		go func() {
+			if err := srv.ListenAndServe(conf.Api.Addr); err != nil && err != http.ErrServerClosed {
+				fmt.Println("error serving api:", err)
+			}
+		}()
+	}
+
+	if err := r.Start(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
 	// Block until a signal is received.
 	s := <-ctx.Done()
 	_ = logger.Log(fmt.Sprintf("Got signal: %s", s))
-}
+	r.Stop()
+	stopPush()
+	<-pushed
 
-func makeLogger(f string, out io.Writer) (log.Logger, error) {
-	switch f {
-	case "logfmt":
-		return log.NewLogfmtLogger(log.NewSyncWriter(out)), nil
-	case "json":
-		return log.NewJSONLogger(log.NewSyncWriter(out)), nil
-	default:
-		return nil, errors.New("unknown log format")
+	if srv != nil {
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutCtx)
 	}
 }
 
 type shutdown func() error
 
-// initTracing initializes the OpenTelemetry stdout exporter.
-func initTracing(ctx context.Context) (shutdown, error) {
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String("zombie"),
-		),
-	)
+// initTracing initializes the OpenTelemetry stdout exporter, the trace
+// journal and OTLP file if enabled, and the span metrics and the debug processor metrics
+// registered with reg.
+func initTracing(res *resource.Resource, reg prometheus.Registerer) (shutdown, error) {
+	// The targets are traced by other processes, if at all, so the client
+	// spans of the pings never pair with a server span here.
+	spans, err := spanmetrics.New(reg, spanmetrics.Opts{
+		ExcludeFromGraph: func(s sdktrace.ReadOnlySpan) bool {
+			return s.SpanKind() == trace.SpanKindClient
+		},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("creating otel resource: %v", err)
+		return nil, fmt.Errorf("creating span metrics: %v", err)
 	}
 
-	debug := debugprocessor.New().WithWriter(os.Stdout).Build()
-	tracerProvider := sdktrace.NewTracerProvider(
+	// Print spans in the same format as the logs
+	encoding, err := debugprocessor.ParseEncoding(*format)
+	if err != nil {
+		return nil, err
+	}
+	debug := debugprocessor.New().WithWriter(os.Stdout).WithEncoding(encoding).WithRegisterer(reg).Build()
+	opts := []sdktrace.TracerProviderOption{
 		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(spans),
 		sdktrace.WithSpanProcessor(debug),
-	)
+	}
+
+	// Keep a journal of every trace as trees of spans, exported in batches
+	// so that writing it doesn't slow the workers down.
+	var journal *debugprocessor.RotatingFile
+	if *journalPath != "" {
+		journal, err = debugprocessor.NewRotatingFile(*journalPath, debugprocessor.RotateOpts{
+			MaxSize:    *journalMaxSize,
+			MaxAge:     *journalMaxAge,
+			MaxBackups: *journalMaxBackups,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating trace journal: %v", err)
+		}
+		exp := debugprocessor.New(
+			debugprocessor.WithWriter(journal),
+			debugprocessor.WithColors(debugprocessor.ColorNever),
+			debugprocessor.WithTimestamps(true),
+			debugprocessor.WithIDs(true),
+			debugprocessor.WithResource(true),
+			debugprocessor.WithRegisterer(reg),
+		).BuildExporter()
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	// Record spans for replay, when no collector is available
+	if *traceFile != "" {
+		f, err := otlpfile.ParseFormat(*traceFileFormat, *traceFile)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := otlpfile.Create(*traceFile, f)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(opts...)
 	otel.SetTracerProvider(tracerProvider)
 
+	// The tracer provider is shut down once the main context is done, so it
+	// gets its own to flush the last spans.
 	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 		if err := tracerProvider.Shutdown(ctx); err != nil {
 			return fmt.Errorf("stopping tracer provider: %v", err)
 		}
+		if journal != nil {
+			if err := journal.Close(); err != nil {
+				return fmt.Errorf("closing trace journal: %v", err)
+			}
+		}
 		return nil
 	}, nil
 }
 
+// initPush creates a metrics pusher for the exporters enabled in conf.
+func initPush(ctx context.Context, conf *config.Metrics, g prometheus.Gatherer, res *resource.Resource) (*push.Pusher, error) {
+	var exporters []push.Exporter
+
+	if conf.OTLP != nil {
+		exp, err := push.NewOTLPExporter(ctx, push.OTLPConfig{
+			Endpoint: conf.OTLP.Endpoint,
+			Insecure: conf.OTLP.Insecure,
+			Headers:  conf.OTLP.Headers,
+			Resource: res,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating OTLP metrics exporter: %v", err)
+		}
+		exporters = append(exporters, exp)
+	}
+
+	if conf.RemoteWrite != nil {
+		exporters = append(exporters, push.NewRemoteWriteExporter(push.RemoteWriteConfig{
+			URL:     conf.RemoteWrite.Url,
+			Headers: conf.RemoteWrite.Headers,
+			Labels:  conf.RemoteWrite.Labels,
+		}))
+	}
+
+	return push.New(g, conf.PushInterval(), logger, exporters...), nil
+}
+
 func printSummary(c config.Config) {
 	fmt.Println("Zombie started")
 	fmt.Printf("version=%s branch=%s revision=%s\n", Version, Branch, Revision)
 
 	if c.Api != nil && c.Api.Enabled {
-		fmt.Printf("API enabled on %s\n", c.Api.Addr)
+		scheme := "http"
+		if c.Api.TLS != nil {
+			scheme = "https"
+		}
+		fmt.Printf("API enabled on %s://%s\n", scheme, c.Api.Addr)
+		if c.Api.Auth != nil {
+			fmt.Printf("API authentication enabled with %d tokens and %d users\n", len(c.Api.Auth.Tokens), len(c.Api.Auth.Users))
+		}
+	}
+
+	if c.Metrics != nil {
+		if c.Metrics.OTLP != nil {
+			fmt.Printf("pushing metrics to OTLP endpoint %s every %s\n", c.Metrics.OTLP.Endpoint, c.Metrics.PushInterval())
+		}
+		if c.Metrics.RemoteWrite != nil {
+			fmt.Printf("pushing metrics to remote write endpoint %s every %s\n", c.Metrics.RemoteWrite.Url, c.Metrics.PushInterval())
+		}
 	}
 
 	for _, t := range c.Targets {
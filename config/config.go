@@ -15,6 +15,9 @@ type Config struct {
 	// API configuration
 	Api *Api `yaml:"api,omitempty"`
 
+	// Metrics push configuration
+	Metrics *Metrics `yaml:"metrics,omitempty"`
+
 	// List of Targets
 	Targets []Target `yaml:"targets"`
 }
@@ -23,6 +26,90 @@ type Config struct {
 type Api struct {
 	Enabled bool   `yaml:"enabled"`
 	Addr    string `yaml:"addr,omitempty"`
+
+	// TraceURL links failed requests shown in the dashboard to their trace.
+	// The `{traceID}` placeholder is replaced by the request's trace ID.
+	TraceURL string `yaml:"trace_url,omitempty"`
+
+	// TLS serves the API over HTTPS when set.
+	TLS *TLS `yaml:"tls,omitempty"`
+
+	// Auth requires every request to authenticate, except for metrics and
+	// health checks.
+	Auth *Auth `yaml:"auth,omitempty"`
+}
+
+// TLS configuration of the API server
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// ClientCAFile enables the verification of client certificates against
+	// the CAs it contains. Clients without a certificate are still accepted
+	// unless RequireClientCert is set.
+	ClientCAFile      string `yaml:"client_ca_file,omitempty"`
+	RequireClientCert bool   `yaml:"require_client_cert,omitempty"`
+}
+
+// Permission levels granted to API credentials
+const (
+	// RoleRead grants access to read-only routes.
+	RoleRead = "read"
+
+	// RoleAdmin grants access to every route, including the ones changing
+	// targets at runtime.
+	RoleAdmin = "admin"
+)
+
+// Auth lists the credentials accepted by the API. Every credential has a
+// role, either `read` or `admin`.
+type Auth struct {
+	// Tokens accepted in an `Authorization: Bearer` header. The dashboard
+	// also accepts them in a `token` query parameter, e.g. `/?token=...`.
+	Tokens []Token `yaml:"tokens,omitempty"`
+
+	// Users accepted with HTTP basic authentication.
+	Users []User `yaml:"users,omitempty"`
+}
+
+type Token struct {
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+type User struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Role     string `yaml:"role"`
+}
+
+// Metrics pushed to remote backends, in addition to being exposed on the API.
+// Pushing ensures that metrics from short-lived runs are not lost.
+type Metrics struct {
+	// Interval between each push, expressed in milliseconds. Defaults to 15s.
+	Interval int64 `yaml:"interval,omitempty"`
+
+	// OTLP pushes metrics to an OpenTelemetry collector.
+	OTLP *OTLP `yaml:"otlp,omitempty"`
+
+	// RemoteWrite pushes metrics to a Prometheus remote write endpoint.
+	RemoteWrite *RemoteWrite `yaml:"remote_write,omitempty"`
+}
+
+// OTLP gRPC exporter configuration
+type OTLP struct {
+	Endpoint string            `yaml:"endpoint"`
+	Insecure bool              `yaml:"insecure,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+}
+
+// Prometheus remote write exporter configuration
+type RemoteWrite struct {
+	Url     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Labels added to every series pushed, like `job` or `instance`.
+	Labels map[string]string `yaml:"labels,omitempty"`
 }
 
 // Target to crawl
@@ -49,7 +136,18 @@ type Target struct {
 	Workers int `yaml:"workers,omitempty"`
 }
 
-const defaultDuration = 1000 * time.Millisecond
+const (
+	defaultDuration     = 1000 * time.Millisecond
+	defaultPushInterval = 15 * time.Second
+)
+
+func (m *Metrics) PushInterval() time.Duration {
+	if m == nil || m.Interval == 0 {
+		return defaultPushInterval
+	}
+
+	return time.Duration(m.Interval) * time.Millisecond
+}
 
 func (t *Target) Duration() time.Duration {
 	if t == nil || t.Delay == 0 {
@@ -66,6 +164,10 @@ func Load(s string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -81,3 +183,51 @@ func LoadFile(fp string) (*Config, error) {
 	}
 	return cfg, nil
 }
+
+// Validate checks that the API credentials are complete and have a known
+// role, so that a typo doesn't silently lock a credential out, and that the
+// metrics push interval isn't negative.
+func (c *Config) Validate() error {
+	if c.Metrics != nil && c.Metrics.Interval < 0 {
+		return fmt.Errorf("metrics: negative interval %d", c.Metrics.Interval)
+	}
+	if c.Api != nil && c.Api.Auth != nil {
+		if err := c.Api.Auth.Validate(); err != nil {
+			return fmt.Errorf("api auth: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every token and user has a secret and a known role.
+func (a *Auth) Validate() error {
+	for i, t := range a.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("token %d: empty token", i)
+		}
+		if err := validateRole(t.Role); err != nil {
+			return fmt.Errorf("token %d: %w", i, err)
+		}
+	}
+	for _, u := range a.Users {
+		if u.Username == "" {
+			return fmt.Errorf("user without a username")
+		}
+		if u.Password == "" {
+			return fmt.Errorf("user %s: empty password", u.Username)
+		}
+		if err := validateRole(u.Role); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+	}
+	return nil
+}
+
+func validateRole(role string) error {
+	switch role {
+	case RoleRead, RoleAdmin:
+		return nil
+	default:
+		return fmt.Errorf("unknown role %q, expected %s or %s", role, RoleRead, RoleAdmin)
+	}
+}
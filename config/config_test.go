@@ -104,6 +104,87 @@ func TestConfig(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("metrics push", func(t *testing.T) {
+		conf, err := Load(metrics)
+		if err != nil {
+			t.Errorf("failed to parse metrics config: %s", err)
+			t.FailNow()
+		}
+
+		if conf.Metrics == nil {
+			t.Errorf("expected metrics to be configured")
+			t.FailNow()
+		}
+
+		if conf.Metrics.PushInterval() != defaultPushInterval {
+			t.Errorf("expected default push interval, got %s", conf.Metrics.PushInterval())
+		}
+
+		if conf.Metrics.OTLP == nil || conf.Metrics.OTLP.Endpoint != "localhost:4317" || !conf.Metrics.OTLP.Insecure {
+			t.Errorf("expected insecure OTLP endpoint localhost:4317, got %+v", conf.Metrics.OTLP)
+		}
+
+		if conf.Metrics.RemoteWrite == nil || conf.Metrics.RemoteWrite.Labels["job"] != "zombie" {
+			t.Errorf("expected remote write with job label, got %+v", conf.Metrics.RemoteWrite)
+		}
+	})
+}
+
+func TestValidateAuth(t *testing.T) {
+	if _, err := Load(auth); err != nil {
+		t.Fatalf("failed to parse auth config: %s", err)
+	}
+
+	invalid := map[string]string{
+		"unknown role": `
+api:
+  auth:
+    tokens:
+      - token: s3cr3t
+        role: amdin
+`,
+		"missing role": `
+api:
+  auth:
+    users:
+      - username: alice
+        password: s3cr3t
+`,
+		"empty password": `
+api:
+  auth:
+    users:
+      - username: alice
+        role: read
+`,
+		"empty token": `
+api:
+  auth:
+    tokens:
+      - role: read
+`,
+	}
+	for name, s := range invalid {
+		if _, err := Load(s); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestValidateMetrics(t *testing.T) {
+	if _, err := Load(`
+metrics:
+  interval: 5000
+`); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if _, err := Load(`
+metrics:
+  interval: -1
+`); err == nil {
+		t.Errorf("expected error for negative interval")
+	}
 }
 
 var simple = `
@@ -137,3 +218,31 @@ targets:
       "Content-Type":
         - "application/json"
 `
+
+var metrics = `
+metrics:
+  otlp:
+    endpoint: localhost:4317
+    insecure: true
+  remote_write:
+    url: http://localhost:9090/api/v1/write
+    labels:
+      job: zombie
+targets:
+  - url: http://example.org
+`
+
+var auth = `
+api:
+  enabled: true
+  auth:
+    tokens:
+      - token: s3cr3t
+        role: admin
+    users:
+      - username: alice
+        password: s3cr3t
+        role: read
+targets:
+  - url: http://example.org
+`
@@ -2,27 +2,200 @@ package debugprocessor
 
 import (
 	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/internal/promutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var defaultBuilder = &Builder{
-	w: defaultWriter,
+// Builder configures a Processor. Builders are not safe for concurrent use,
+// but can build any number of independent processors.
+type Builder struct {
+	w        io.Writer
+	timeout  time.Duration
+	colors   ColorMode
+	live     bool
+	encoding Encoding
+	format   format
+	filter   filter
+	every    uint64
+	rate     float64
+
+	queueSize int
+	reg       prometheus.Registerer
 }
 
-type Builder struct {
-	w io.Writer
+// New returns a Builder with the default settings, and opts applied.
+func New(opts ...Option) *Builder {
+	b := &Builder{
+		w:         defaultWriter,
+		timeout:   defaultTimeout,
+		encoding:  EncodingText,
+		queueSize: defaultQueueSize,
+	}
+	return b.With(opts...)
 }
 
-func New() *Builder {
-	return defaultBuilder
+// NewProcessor creates a Processor with the default settings, and opts
+// applied.
+func NewProcessor(opts ...Option) *Processor {
+	return New(opts...).Build()
 }
 
-func (b *Builder) WithWriter(w io.Writer) *Builder {
-	b.w = w
+// With applies opts to the builder.
+func (b *Builder) With(opts ...Option) *Builder {
+	for _, opt := range opts {
+		opt(b)
+	}
 	return b
 }
 
+// WithWriter applies the WithWriter option.
+func (b *Builder) WithWriter(w io.Writer) *Builder {
+	return b.With(WithWriter(w))
+}
+
+// WithTimeout applies the WithTimeout option.
+func (b *Builder) WithTimeout(d time.Duration) *Builder {
+	return b.With(WithTimeout(d))
+}
+
+// WithColors applies the WithColors option.
+func (b *Builder) WithColors(mode ColorMode) *Builder {
+	return b.With(WithColors(mode))
+}
+
+// WithTimestamps applies the WithTimestamps option.
+func (b *Builder) WithTimestamps(enabled bool) *Builder {
+	return b.With(WithTimestamps(enabled))
+}
+
+// WithIDs applies the WithIDs option.
+func (b *Builder) WithIDs(enabled bool) *Builder {
+	return b.With(WithIDs(enabled))
+}
+
+// WithDurationUnit applies the WithDurationUnit option.
+func (b *Builder) WithDurationUnit(unit time.Duration) *Builder {
+	return b.With(WithDurationUnit(unit))
+}
+
+// WithAttributeFilter applies the WithAttributeFilter option.
+func (b *Builder) WithAttributeFilter(include, exclude []string) *Builder {
+	return b.With(WithAttributeFilter(include, exclude))
+}
+
+// WithResource applies the WithResource option.
+func (b *Builder) WithResource(enabled bool) *Builder {
+	return b.With(WithResource(enabled))
+}
+
+// WithLive applies the WithLive option.
+func (b *Builder) WithLive(enabled bool) *Builder {
+	return b.With(WithLive(enabled))
+}
+
+// WithEncoding applies the WithEncoding option.
+func (b *Builder) WithEncoding(e Encoding) *Builder {
+	return b.With(WithEncoding(e))
+}
+
+// WithMinDuration applies the WithMinDuration option.
+func (b *Builder) WithMinDuration(d time.Duration) *Builder {
+	return b.With(WithMinDuration(d))
+}
+
+// WithErrorsOnly applies the WithErrorsOnly option.
+func (b *Builder) WithErrorsOnly(enabled bool) *Builder {
+	return b.With(WithErrorsOnly(enabled))
+}
+
+// WithNameFilter applies the WithNameFilter option.
+func (b *Builder) WithNameFilter(include, exclude []string) *Builder {
+	return b.With(WithNameFilter(include, exclude))
+}
+
+// WithLibraryFilter applies the WithLibraryFilter option.
+func (b *Builder) WithLibraryFilter(include, exclude []string) *Builder {
+	return b.With(WithLibraryFilter(include, exclude))
+}
+
+// WithAttributePredicate applies the WithAttributePredicate option.
+func (b *Builder) WithAttributePredicate(key attribute.Key, fn func(attribute.Value) bool) *Builder {
+	return b.With(WithAttributePredicate(key, fn))
+}
+
+// WithSampling applies the WithSampling option.
+func (b *Builder) WithSampling(n uint64) *Builder {
+	return b.With(WithSampling(n))
+}
+
+// WithRateLimit applies the WithRateLimit option.
+func (b *Builder) WithRateLimit(perSecond float64) *Builder {
+	return b.With(WithRateLimit(perSecond))
+}
+
+// WithQueueSize applies the WithQueueSize option.
+func (b *Builder) WithQueueSize(n int) *Builder {
+	return b.With(WithQueueSize(n))
+}
+
+// WithRegisterer applies the WithRegisterer option.
+func (b *Builder) WithRegisterer(reg prometheus.Registerer) *Builder {
+	return b.With(WithRegisterer(reg))
+}
+
+// Build creates the Processor. Filters apply to spans individually: in trees,
+// the children of a span filtered out take its place. In live mode, only the
+// name, library and attribute filters apply to enter lines.
 func (b *Builder) Build() *Processor {
+	f := b.format
+	f.colors = b.encoding == EncodingText && useColors(b.colors, b.w)
+
+	var out lineWriter = &syncWriter{out: b.w}
+	if b.queueSize > 0 {
+		out = newAsyncWriter(b.w, b.queueSize, droppedLines(b.reg))
+	}
+
 	return &Processor{
-		out: b.w,
+		out:      out,
+		format:   f,
+		timeout:  b.timeout,
+		live:     b.live,
+		encoding: b.encoding,
+		filter:   b.filter.clone(),
+		sampler:  newSampler(b.every, b.rate, b.timeout),
+		traces:   make(map[trace.TraceID]*pending),
+		active:   make(map[trace.SpanID]liveSpan),
+	}
+}
+
+// BuildExporter creates an Exporter. Live mode is ignored, since exporters
+// only see ended spans.
+func (b *Builder) BuildExporter() *Exporter {
+	exp := *b
+	exp.live = false
+	return &Exporter{p: exp.Build()}
+}
+
+// droppedLines returns the counter of dropped lines registered with reg, or
+// nil if reg is nil.
+func droppedLines(reg prometheus.Registerer) prometheus.Counter {
+	if reg == nil {
+		return nil
+	}
+
+	c := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "debugprocessor_dropped_lines_total",
+		Help: "A counter of lines dropped because the debug processor's queue was full.",
+	})
+	registered, err := promutil.Register(reg, c)
+	if err != nil {
+		otel.Handle(err)
+		return nil
 	}
+	return registered.(prometheus.Counter)
 }
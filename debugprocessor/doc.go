@@ -5,4 +5,8 @@
 // It's inspired by Rust's tracing-tree crate that does a wonderful job of
 // displaying trace information in a format that is convenient to consume in a
 // terminal context.
+//
+// The same output is available from an Exporter, to sit behind a batch span
+// processor, and can be written to a RotatingFile to keep a local journal of
+// traces.
 package debugprocessor
@@ -0,0 +1,135 @@
+package debugprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Encoding is the output format of a Processor.
+type Encoding string
+
+const (
+	// EncodingText prints indented trees of spans meant for humans.
+	EncodingText Encoding = "text"
+
+	// EncodingJSON and EncodingLogfmt print one line per ended span, in the
+	// same formats as the go-kit loggers used by the commands of this module.
+	// Spans are printed as soon as they end, without building trees.
+	EncodingJSON   Encoding = "json"
+	EncodingLogfmt Encoding = "logfmt"
+)
+
+// ParseEncoding returns the Encoding named s.
+func ParseEncoding(s string) (Encoding, error) {
+	switch e := Encoding(s); e {
+	case EncodingText, EncodingJSON, EncodingLogfmt:
+		return e, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", s)
+	}
+}
+
+// encode writes s as a single JSON or logfmt line. Attributes, events and
+// resource are nested objects in JSON, and flattened with prefixed keys in
+// logfmt, e.g. attr.http.method=GET.
+func (f *format) encode(buf *bytes.Buffer, enc Encoding, s sdktrace.ReadOnlySpan) {
+	nested := enc == EncodingJSON
+
+	keyvals := []interface{}{
+		"trace_id", s.SpanContext().TraceID().String(),
+		"span_id", s.SpanContext().SpanID().String(),
+	}
+	if s.Parent().IsValid() {
+		keyvals = append(keyvals, "parent_id", s.Parent().SpanID().String())
+	}
+	keyvals = append(keyvals,
+		"name", s.Name(),
+		"library", s.InstrumentationLibrary().Name,
+		"kind", s.SpanKind().String(),
+		"status", s.Status().Code.String(),
+	)
+	if s.Status().Description != "" {
+		keyvals = append(keyvals, "status_message", s.Status().Description)
+	}
+	keyvals = append(keyvals,
+		"start", s.StartTime().UTC().Format(time.RFC3339Nano),
+		"duration_ms", float64(s.EndTime().Sub(s.StartTime()))/float64(time.Millisecond),
+	)
+
+	attrs := f.filter(s.Attributes())
+	events := s.Events()
+	var res []attribute.KeyValue
+	if s.Resource() != nil {
+		res = s.Resource().Attributes()
+	}
+
+	if nested {
+		keyvals = append(keyvals, "attributes", attributeMap(attrs))
+		if len(events) > 0 {
+			es := make([]map[string]interface{}, 0, len(events))
+			for _, e := range events {
+				es = append(es, map[string]interface{}{
+					"name":       e.Name,
+					"time":       e.Time.UTC().Format(time.RFC3339Nano),
+					"attributes": attributeMap(f.filter(e.Attributes)),
+				})
+			}
+			keyvals = append(keyvals, "events", es)
+		}
+		keyvals = append(keyvals, "resource", attributeMap(res))
+	} else {
+		keyvals = appendFlat(keyvals, "attr.", attrs)
+		for i, e := range events {
+			prefix := "event." + strconv.Itoa(i) + "."
+			keyvals = append(keyvals,
+				prefix+"name", e.Name,
+				prefix+"time", e.Time.UTC().Format(time.RFC3339Nano),
+			)
+			keyvals = appendFlat(keyvals, prefix, f.filter(e.Attributes))
+		}
+		keyvals = appendFlat(keyvals, "resource.", res)
+	}
+
+	var logger log.Logger
+	if nested {
+		logger = log.NewJSONLogger(buf)
+	} else {
+		logger = log.NewLogfmtLogger(buf)
+	}
+	_ = logger.Log(keyvals...)
+}
+
+// filter returns the attributes kept by the include and exclude filters.
+func (f *format) filter(kv []attribute.KeyValue) []attribute.KeyValue {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return kv
+	}
+	kept := make([]attribute.KeyValue, 0, len(kv))
+	for _, pair := range kv {
+		if f.keep(string(pair.Key)) {
+			kept = append(kept, pair)
+		}
+	}
+	return kept
+}
+
+func attributeMap(kv []attribute.KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(kv))
+	for _, pair := range kv {
+		m[string(pair.Key)] = pair.Value.AsInterface()
+	}
+	return m
+}
+
+func appendFlat(keyvals []interface{}, prefix string, kv []attribute.KeyValue) []interface{} {
+	for _, pair := range kv {
+		keyvals = append(keyvals, prefix+string(pair.Key), pair.Value.Emit())
+	}
+	return keyvals
+}
@@ -0,0 +1,39 @@
+package debugprocessor
+
+import (
+	"context"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var _ sdktrace.SpanExporter = &Exporter{}
+
+// Exporter is an implementation of sdktrace.SpanExporter printing spans like
+// a Processor, so that it can sit behind a batch span processor. Exporters
+// only see ended spans, so live mode doesn't apply to them.
+type Exporter struct {
+	p *Processor
+}
+
+// ExportSpans prints spans, buffering them per trace until their local root
+// is exported like Processor.OnEnd.
+func (e *Exporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, s := range spans {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e.p.OnEnd(s)
+	}
+	return nil
+}
+
+// Dropped returns the number of lines dropped because the queue was full.
+func (e *Exporter) Dropped() uint64 {
+	return e.p.Dropped()
+}
+
+// Shutdown prints the spans of every trace still buffered, then stops
+// writing.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.p.Shutdown(ctx)
+}
@@ -0,0 +1,195 @@
+package debugprocessor
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/wperron/o11yutil/internal/glob"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// filter decides which spans are printed. Spans are printed only if they
+// pass every filter.
+type filter struct {
+	minDuration time.Duration
+	errorsOnly  bool
+
+	// names and libraries are glob.Match patterns of span names and
+	// instrumentation library names.
+	includeNames     []string
+	excludeNames     []string
+	includeLibraries []string
+	excludeLibraries []string
+
+	predicates []predicate
+}
+
+// clone returns a copy of f not sharing its predicates, so that a Builder
+// adding predicates after Build doesn't change the processors built so far.
+func (f filter) clone() filter {
+	f.predicates = append([]predicate(nil), f.predicates...)
+	return f
+}
+
+// predicate requires a span to have an attribute for which fn is true.
+type predicate struct {
+	key attribute.Key
+	fn  func(attribute.Value) bool
+}
+
+// match reports whether s passes the filters known when it starts: its name,
+// library and attributes.
+func (f *filter) match(s sdktrace.ReadOnlySpan) bool {
+	if !matchGlobs(s.Name(), f.includeNames, f.excludeNames) {
+		return false
+	}
+	if !matchGlobs(s.InstrumentationLibrary().Name, f.includeLibraries, f.excludeLibraries) {
+		return false
+	}
+
+	for _, p := range f.predicates {
+		found := false
+		for _, kv := range s.Attributes() {
+			if kv.Key == p.key && p.fn(kv.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// outcome reports whether the ended span s passes the duration and status
+// filters.
+func (f *filter) outcome(s sdktrace.ReadOnlySpan) bool {
+	if f.errorsOnly && s.Status().Code != codes.Error {
+		return false
+	}
+	return s.EndTime().Sub(s.StartTime()) >= f.minDuration
+}
+
+// matchGlobs reports whether s matches one of the include patterns, if any,
+// and none of the exclude patterns.
+func matchGlobs(s string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if glob.Match(pattern, s) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if glob.Match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampler decides which traces are printed, keeping or dropping all the
+// spans of a trace together.
+type sampler struct {
+	// every keeps 1 in every traces, based on the trace ID so that the same
+	// traces are kept by every process.
+	every uint64
+
+	// rate limits the number of traces kept per second. Decisions are
+	// remembered for ttl so that the later spans of a trace follow the first.
+	rate float64
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	tokens    float64
+	last      time.Time
+	swept     time.Time
+	decisions map[trace.TraceID]decision
+	now       func() time.Time
+}
+
+type decision struct {
+	keep bool
+	at   time.Time
+}
+
+func newSampler(every uint64, rate float64, ttl time.Duration) *sampler {
+	if every <= 1 && rate <= 0 {
+		return nil
+	}
+	s := &sampler{
+		every:     every,
+		rate:      rate,
+		ttl:       ttl,
+		decisions: make(map[trace.TraceID]decision),
+		now:       time.Now,
+	}
+	s.tokens = s.burst()
+	return s
+}
+
+// sample reports whether the spans of the trace should be printed. A nil
+// sampler keeps every trace.
+func (s *sampler) sample(id trace.TraceID) bool {
+	if s == nil {
+		return true
+	}
+	if s.every > 1 && binary.BigEndian.Uint64(id[8:])%s.every != 0 {
+		return false
+	}
+	if s.rate <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if d, ok := s.decisions[id]; ok {
+		return d.keep
+	}
+	s.expire(now)
+
+	// Refill the bucket, holding at most a second worth of traces.
+	if !s.last.IsZero() {
+		s.tokens += now.Sub(s.last).Seconds() * s.rate
+		if burst := s.burst(); s.tokens > burst {
+			s.tokens = burst
+		}
+	}
+	s.last = now
+
+	keep := s.tokens >= 1
+	if keep {
+		s.tokens--
+	}
+	s.decisions[id] = decision{keep: keep, at: now}
+	return keep
+}
+
+func (s *sampler) burst() float64 {
+	if s.rate < 1 {
+		return 1
+	}
+	return s.rate
+}
+
+// expire forgets the decisions older than the ttl, at most once per ttl.
+// The lock must be held.
+func (s *sampler) expire(now time.Time) {
+	if now.Sub(s.swept) < s.ttl {
+		return
+	}
+	s.swept = now
+	for id, d := range s.decisions {
+		if now.Sub(d.at) > s.ttl {
+			delete(s.decisions, id)
+		}
+	}
+}
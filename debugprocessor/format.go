@@ -0,0 +1,333 @@
+package debugprocessor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wperron/o11yutil/internal/glob"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ColorMode controls the use of ANSI colors in the output.
+type ColorMode int
+
+const (
+	// ColorAuto uses colors when writing to a terminal, unless the NO_COLOR
+	// environment variable is set.
+	ColorAuto ColorMode = iota
+	ColorAlways
+	ColorNever
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+)
+
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// format holds the options used to render spans.
+type format struct {
+	colors     bool
+	timestamps bool
+	ids        bool
+	resource   bool
+
+	// unit of durations, or 0 to pick one depending on the duration.
+	unit time.Duration
+
+	// include and exclude are glob.Match patterns of attribute keys.
+	include []string
+	exclude []string
+}
+
+// useColors resolves mode for the writer w.
+func useColors(mode ColorMode, w io.Writer) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// render writes the tree rooted at root, one line per span and event:
+//
+//	lib::root{k=v} 1.2s
+//	├─ lib::child{k=v} +12ms 300ms
+//	│  · event{k=v} +20ms
+//	└─ lib::child{k=v} +400ms 600ms error: failed
+func (f *format) render(buf *bytes.Buffer, root *node) {
+	f.renderNode(buf, root, "", "", root.span.StartTime())
+}
+
+// renderNode writes n prefixed with branch, and its events and children
+// prefixed with indent.
+func (f *format) renderNode(buf *bytes.Buffer, n *node, branch, indent string, origin time.Time) {
+	s := n.span
+	f.timestamp(buf, s.StartTime())
+	buf.WriteString(branch)
+	f.spanName(buf, s.InstrumentationLibrary().Name, s.Name(), s.SpanKind())
+	f.attributes(buf, s.Attributes())
+	if s.StartTime() != origin {
+		buf.WriteByte(' ')
+		f.dim(buf, "+"+f.duration(s.StartTime().Sub(origin)))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(f.duration(s.EndTime().Sub(s.StartTime())))
+	f.status(buf, s.Status().Code, s.Status().Description)
+	if branch == "" && f.resource && s.Resource() != nil {
+		buf.WriteByte(' ')
+		f.dim(buf, "resource")
+		f.writeAttributes(buf, s.Resource().Attributes(), false)
+	}
+	f.spanIDs(buf, s.SpanContext(), branch == "")
+	buf.WriteByte('\n')
+
+	eventIndent := indent + "   "
+	if len(n.children) > 0 {
+		eventIndent = indent + "│  "
+	}
+	f.events(buf, s, eventIndent, origin)
+
+	for i, c := range n.children {
+		if i == len(n.children)-1 {
+			f.renderNode(buf, c, indent+"└─ ", indent+"   ", origin)
+		} else {
+			f.renderNode(buf, c, indent+"├─ ", indent+"│  ", origin)
+		}
+	}
+}
+
+// enter writes the line of a span starting in live mode:
+//
+//	│  → lib::child{k=v} +12ms
+func (f *format) enter(buf *bytes.Buffer, s sdktrace.ReadOnlySpan, depth int, origin time.Time) {
+	indent := strings.Repeat("│  ", depth)
+	f.timestamp(buf, s.StartTime())
+	buf.WriteString(indent)
+	buf.WriteString("→ ")
+	f.spanName(buf, s.InstrumentationLibrary().Name, s.Name(), s.SpanKind())
+	f.attributes(buf, s.Attributes())
+	if s.StartTime() != origin {
+		buf.WriteByte(' ')
+		f.dim(buf, "+"+f.duration(s.StartTime().Sub(origin)))
+	}
+	if depth == 0 && f.resource && s.Resource() != nil {
+		buf.WriteByte(' ')
+		f.dim(buf, "resource")
+		f.writeAttributes(buf, s.Resource().Attributes(), false)
+	}
+	f.spanIDs(buf, s.SpanContext(), depth == 0)
+	buf.WriteByte('\n')
+}
+
+// exit writes the events and links recorded while a span was active in live
+// mode, followed by the line of the span ending:
+//
+//	│  │  · event{k=v} +20ms
+//	│  ← lib::child 300ms error: failed
+func (f *format) exit(buf *bytes.Buffer, s sdktrace.ReadOnlySpan, depth int, origin time.Time) {
+	indent := strings.Repeat("│  ", depth)
+	f.events(buf, s, indent+"│  ", origin)
+
+	f.timestamp(buf, s.EndTime())
+	buf.WriteString(indent)
+	buf.WriteString("← ")
+	f.spanName(buf, s.InstrumentationLibrary().Name, s.Name(), s.SpanKind())
+	buf.WriteByte(' ')
+	buf.WriteString(f.duration(s.EndTime().Sub(s.StartTime())))
+	f.status(buf, s.Status().Code, s.Status().Description)
+	buf.WriteByte('\n')
+}
+
+// events writes the events and links of s, one per line prefixed with
+// indent.
+func (f *format) events(buf *bytes.Buffer, s sdktrace.ReadOnlySpan, indent string, origin time.Time) {
+	for _, e := range s.Events() {
+		f.timestamp(buf, e.Time)
+		buf.WriteString(indent)
+		buf.WriteString("· ")
+		buf.WriteString(e.Name)
+		f.attributes(buf, e.Attributes)
+		buf.WriteByte(' ')
+		f.dim(buf, "+"+f.duration(e.Time.Sub(origin)))
+		buf.WriteByte('\n')
+	}
+
+	for _, l := range s.Links() {
+		f.timestamp(buf, s.StartTime())
+		buf.WriteString(indent)
+		buf.WriteString("↪ link")
+		f.attributes(buf, l.Attributes)
+		buf.WriteByte(' ')
+		f.dim(buf, "trace_id="+l.SpanContext.TraceID().String()+" span_id="+l.SpanContext.SpanID().String())
+		buf.WriteByte('\n')
+	}
+}
+
+func (f *format) timestamp(buf *bytes.Buffer, t time.Time) {
+	if !f.timestamps {
+		return
+	}
+	f.dim(buf, t.Format(timestampLayout))
+	buf.WriteByte(' ')
+}
+
+// spanName writes lib::name, colored by the kind of span.
+func (f *format) spanName(buf *bytes.Buffer, lib, name string, kind trace.SpanKind) {
+	var color string
+	switch kind {
+	case trace.SpanKindServer:
+		color = ansiCyan
+	case trace.SpanKindClient:
+		color = ansiMagenta
+	case trace.SpanKindProducer, trace.SpanKindConsumer:
+		color = ansiYellow
+	default:
+		color = ansiBold
+	}
+	f.color(buf, color, lib+"::"+name)
+}
+
+func (f *format) status(buf *bytes.Buffer, code codes.Code, description string) {
+	switch code {
+	case codes.Error:
+		buf.WriteByte(' ')
+		if description != "" {
+			f.color(buf, ansiRed, "error: "+description)
+		} else {
+			f.color(buf, ansiRed, "error")
+		}
+	case codes.Ok:
+		buf.WriteByte(' ')
+		f.color(buf, ansiGreen, "ok")
+	}
+}
+
+// spanIDs writes the span ID, and the trace ID as well for roots.
+func (f *format) spanIDs(buf *bytes.Buffer, sc trace.SpanContext, root bool) {
+	if !f.ids {
+		return
+	}
+	if root {
+		buf.WriteByte(' ')
+		f.dim(buf, "trace_id="+sc.TraceID().String())
+	}
+	buf.WriteByte(' ')
+	f.dim(buf, "span_id="+sc.SpanID().String())
+}
+
+// attributes writes the attributes kept by the filters as {k=v, k=v}.
+func (f *format) attributes(buf *bytes.Buffer, kv []attribute.KeyValue) {
+	f.writeAttributes(buf, kv, true)
+}
+
+func (f *format) writeAttributes(buf *bytes.Buffer, kv []attribute.KeyValue, filter bool) {
+	if filter {
+		kv = f.filter(kv)
+	}
+
+	buf.WriteByte('{')
+	for i, pair := range kv {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		f.dim(buf, string(pair.Key)+"=")
+		buf.WriteString(pair.Value.Emit())
+	}
+	buf.WriteByte('}')
+}
+
+// keep reports whether the attribute key passes the include and exclude
+// filters. Excludes take precedence over includes.
+func (f *format) keep(key string) bool {
+	for _, pattern := range f.exclude {
+		if glob.Match(pattern, key) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if glob.Match(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// duration formats d in the configured unit, or rounded to keep three
+// significant digits or so when there is none.
+func (f *format) duration(d time.Duration) string {
+	if f.unit > 0 {
+		v := strconv.FormatFloat(float64(d)/float64(f.unit), 'f', 3, 64)
+		return strings.TrimSuffix(strings.TrimRight(v, "0"), ".") + unitSuffix(f.unit)
+	}
+
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
+
+func unitSuffix(unit time.Duration) string {
+	switch unit {
+	case time.Nanosecond:
+		return "ns"
+	case time.Microsecond:
+		return "µs"
+	case time.Millisecond:
+		return "ms"
+	case time.Second:
+		return "s"
+	case time.Minute:
+		return "m"
+	case time.Hour:
+		return "h"
+	default:
+		return "×" + unit.String()
+	}
+}
+
+func (f *format) dim(buf *bytes.Buffer, s string) {
+	f.color(buf, ansiDim, s)
+}
+
+func (f *format) color(buf *bytes.Buffer, color, s string) {
+	if !f.colors {
+		buf.WriteString(s)
+		return
+	}
+	buf.WriteString(color)
+	buf.WriteString(s)
+	buf.WriteString(ansiReset)
+}
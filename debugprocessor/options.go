@@ -0,0 +1,170 @@
+package debugprocessor
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Option configures a Builder. Every option can also be applied with the
+// Builder method of the same name.
+type Option func(*Builder)
+
+// WithWriter sets where spans are printed. Defaults to os.Stdout.
+func WithWriter(w io.Writer) Option {
+	return func(b *Builder) {
+		b.w = w
+	}
+}
+
+// WithTimeout sets how long spans of a trace are buffered waiting for their
+// local root to end. Past that, the spans ended so far are printed anyway.
+func WithTimeout(d time.Duration) Option {
+	return func(b *Builder) {
+		b.timeout = d
+	}
+}
+
+// WithColors sets when to use ANSI colors, by kind of span and status.
+// Defaults to ColorAuto, using colors only when writing to a terminal.
+func WithColors(mode ColorMode) Option {
+	return func(b *Builder) {
+		b.colors = mode
+	}
+}
+
+// WithTimestamps prefixes every line with the time the span started, or the
+// time of the event.
+func WithTimestamps(enabled bool) Option {
+	return func(b *Builder) {
+		b.format.timestamps = enabled
+	}
+}
+
+// WithIDs adds the span ID to every span, and the trace ID to roots.
+func WithIDs(enabled bool) Option {
+	return func(b *Builder) {
+		b.format.ids = enabled
+	}
+}
+
+// WithDurationUnit prints every duration in unit, e.g. time.Millisecond.
+// By default, the unit depends on the duration.
+func WithDurationUnit(unit time.Duration) Option {
+	return func(b *Builder) {
+		b.format.unit = unit
+	}
+}
+
+// WithAttributeFilter only prints the attributes whose key matches one of the
+// include patterns, if any, and none of the exclude patterns. In patterns,
+// `*` matches any sequence of characters and `?` any single one, e.g.
+// `http.*`.
+func WithAttributeFilter(include, exclude []string) Option {
+	return func(b *Builder) {
+		b.format.include = include
+		b.format.exclude = exclude
+	}
+}
+
+// WithResource prints the resource attributes on the root of each tree.
+func WithResource(enabled bool) Option {
+	return func(b *Builder) {
+		b.format.resource = enabled
+	}
+}
+
+// WithLive prints spans as they start and end rather than as trees once
+// their local root ends, like tracing-tree's verbose entry and exit mode.
+func WithLive(enabled bool) Option {
+	return func(b *Builder) {
+		b.live = enabled
+	}
+}
+
+// WithEncoding sets the output format. Defaults to EncodingText. The tree,
+// live and colors options only apply to EncodingText.
+func WithEncoding(e Encoding) Option {
+	return func(b *Builder) {
+		b.encoding = e
+	}
+}
+
+// WithMinDuration only prints spans lasting at least d.
+func WithMinDuration(d time.Duration) Option {
+	return func(b *Builder) {
+		b.filter.minDuration = d
+	}
+}
+
+// WithErrorsOnly only prints spans with an error status.
+func WithErrorsOnly(enabled bool) Option {
+	return func(b *Builder) {
+		b.filter.errorsOnly = enabled
+	}
+}
+
+// WithNameFilter only prints the spans whose name matches one of the include
+// patterns, if any, and none of the exclude patterns. In patterns, `*`
+// matches any sequence of characters, slashes included, e.g. `GET /users*`.
+func WithNameFilter(include, exclude []string) Option {
+	return func(b *Builder) {
+		b.filter.includeNames = include
+		b.filter.excludeNames = exclude
+	}
+}
+
+// WithLibraryFilter only prints the spans whose instrumentation library
+// matches one of the include patterns, if any, and none of the exclude
+// patterns. In patterns, `*` matches any sequence of characters, slashes
+// included, e.g. `go.opentelemetry.io/*`.
+func WithLibraryFilter(include, exclude []string) Option {
+	return func(b *Builder) {
+		b.filter.includeLibraries = include
+		b.filter.excludeLibraries = exclude
+	}
+}
+
+// WithAttributePredicate only prints the spans with a key attribute for
+// which fn returns true. Several predicates must all be satisfied.
+func WithAttributePredicate(key attribute.Key, fn func(attribute.Value) bool) Option {
+	return func(b *Builder) {
+		b.filter.predicates = append(b.filter.predicates, predicate{key: key, fn: fn})
+	}
+}
+
+// WithSampling only prints 1 in every n traces, picked from their trace ID so
+// that all the spans of a trace are kept or dropped together.
+func WithSampling(n uint64) Option {
+	return func(b *Builder) {
+		b.every = n
+	}
+}
+
+// WithRateLimit prints at most perSecond traces per second, keeping or
+// dropping all the spans of a trace together.
+func WithRateLimit(perSecond float64) Option {
+	return func(b *Builder) {
+		b.rate = perSecond
+	}
+}
+
+// WithQueueSize sets the number of trees or lines queued for the writer.
+// Past that, lines are dropped rather than blocking the application. A size
+// of 0 writes synchronously instead. Defaults to 1024.
+func WithQueueSize(n int) Option {
+	return func(b *Builder) {
+		b.queueSize = n
+	}
+}
+
+// WithRegisterer registers the debugprocessor_dropped_lines_total counter of
+// lines dropped because the queue was full with reg. Processors built with
+// the same registerer share the counter.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(b *Builder) {
+		b.reg = reg
+	}
+}
@@ -1,44 +1,242 @@
 package debugprocessor
 
 import (
+	"bytes"
 	"context"
-	"fmt"
-	"io"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const defaultTimeout = 30 * time.Second
+
 var (
 	defaultWriter                        = os.Stdout
 	_             sdktrace.SpanProcessor = &Processor{}
 )
 
-// Processor is an implementation of trace.SpanSyncer that writes spans to stdout.
+// Processor is an implementation of sdktrace.SpanProcessor that writes traces
+// to stdout, or any other io.Writer, as trees of spans.
+//
+// Ended spans are buffered per trace until their local root ends, that is the
+// span without a parent or with a remote parent, at which point the whole
+// tree is printed at once. Spans that don't make it into a tree before the
+// timeout, because their root never ends or ends after them, are printed on
+// their own.
+//
+// In live mode, spans are instead printed as they start and end, indented
+// under their parent, so that long-running spans show up right away.
+//
+// Output is written from a separate goroutine through a bounded queue by
+// default, so that a slow writer doesn't block the application. Lines are
+// dropped when the queue is full.
 type Processor struct {
-	// Output Writer used to print new spans to.
-	out io.Writer
+	// Output used to print new spans to.
+	out      lineWriter
+	format   format
+	timeout  time.Duration
+	live     bool
+	encoding Encoding
+	filter   filter
+	sampler  *sampler
+
+	mu     sync.Mutex
+	traces map[trace.TraceID]*pending
+	// active spans in live mode.
+	active map[trace.SpanID]liveSpan
+}
+
+// pending holds the ended spans of a trace not printed yet.
+type pending struct {
+	spans []sdktrace.ReadOnlySpan
+	timer *time.Timer
 }
 
-func (p *Processor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+// liveSpan is the position of an active span in live mode.
+type liveSpan struct {
+	depth int
+	// origin is the start time of the local root, which offsets are relative
+	// to.
+	origin time.Time
+	// hidden spans are filtered out, their children take their place.
+	hidden bool
+}
+
+// childDepth is the depth of the children of the span.
+func (l liveSpan) childDepth() int {
+	if l.hidden {
+		return l.depth
+	}
+	return l.depth + 1
+}
+
+// OnStart prints the span in live mode. Only the name, library and attribute
+// filters apply at that point, since the outcome of the span isn't known yet.
+func (p *Processor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if !p.live || p.encoding != EncodingText {
+		return
+	}
+
+	pos := liveSpan{origin: s.StartTime()}
+	p.mu.Lock()
+	if parent, ok := p.active[s.Parent().SpanID()]; ok && s.Parent().IsValid() {
+		pos = liveSpan{depth: parent.childDepth(), origin: parent.origin}
+	}
+	pos.hidden = !p.sampler.sample(s.SpanContext().TraceID()) || !p.filter.match(s)
+	p.active[s.SpanContext().SpanID()] = pos
+	p.mu.Unlock()
+
+	if pos.hidden {
+		return
+	}
+	var buf bytes.Buffer
+	p.format.enter(&buf, s, pos.depth, pos.origin)
+	p.output(buf.Bytes())
+}
 
 func (p *Processor) OnEnd(s sdktrace.ReadOnlySpan) {
-	fmt.Fprintf(p.out, "%s::%s{%s}\n",
-		s.InstrumentationLibrary().Name,
-		s.Name(),
-		kvToString(s),
-	)
-}
-func (p *Processor) ForceFlush(ctx context.Context) error { return nil }
-func (p *Processor) Shutdown(ctx context.Context) error   { return nil }
-
-func kvToString(s sdktrace.ReadOnlySpan) string {
-	kv := s.Attributes()
-	asStrings := make([]string, 0, len(kv)+1)
-	for _, pair := range kv {
-		asStrings = append(asStrings, fmt.Sprintf("%s=%s", pair.Key, pair.Value.Emit()))
-	}
-	asStrings = append(asStrings, fmt.Sprintf("%s=%d", "latency", s.EndTime().Sub(s.StartTime()).Milliseconds()))
-	return strings.Join(asStrings, ", ")
+	if p.encoding != EncodingText {
+		if p.sampler.sample(s.SpanContext().TraceID()) && p.keep(s) {
+			var buf bytes.Buffer
+			p.format.encode(&buf, p.encoding, s)
+			p.output(buf.Bytes())
+		}
+		return
+	}
+
+	if p.live {
+		p.mu.Lock()
+		pos, ok := p.active[s.SpanContext().SpanID()]
+		delete(p.active, s.SpanContext().SpanID())
+		p.mu.Unlock()
+		if !ok {
+			pos = liveSpan{origin: s.StartTime(), hidden: !p.sampler.sample(s.SpanContext().TraceID())}
+		}
+		if pos.hidden || !p.keep(s) {
+			return
+		}
+
+		var buf bytes.Buffer
+		p.format.exit(&buf, s, pos.depth, pos.origin)
+		p.output(buf.Bytes())
+		return
+	}
+
+	traceID := s.SpanContext().TraceID()
+	if !p.sampler.sample(traceID) {
+		return
+	}
+
+	p.mu.Lock()
+	pend, ok := p.traces[traceID]
+	if !ok {
+		pend = &pending{}
+		pend.timer = time.AfterFunc(p.timeout, func() { p.flushTrace(traceID, pend) })
+		p.traces[traceID] = pend
+	}
+	pend.spans = append(pend.spans, s)
+
+	if !isLocalRoot(s) {
+		p.mu.Unlock()
+		return
+	}
+
+	root, rest := extract(pend.spans, s.SpanContext().SpanID())
+	pend.spans = rest
+	if len(rest) == 0 {
+		pend.timer.Stop()
+		delete(p.traces, traceID)
+	}
+	p.mu.Unlock()
+
+	if root != nil {
+		p.write([]*node{root})
+	}
+}
+
+// Dropped returns the number of lines dropped because the queue was full.
+func (p *Processor) Dropped() uint64 {
+	return p.out.dropped()
+}
+
+// ForceFlush prints the spans of every trace still buffered, and waits for
+// the queue to be written out.
+func (p *Processor) ForceFlush(ctx context.Context) error {
+	p.mu.Lock()
+	traces := p.traces
+	p.traces = make(map[trace.TraceID]*pending)
+	p.mu.Unlock()
+
+	for _, pend := range traces {
+		pend.timer.Stop()
+		p.write(buildTree(pend.spans))
+	}
+	return p.out.flush(ctx)
+}
+
+// Shutdown flushes the processor, then stops writing.
+func (p *Processor) Shutdown(ctx context.Context) error {
+	if err := p.ForceFlush(ctx); err != nil {
+		return err
+	}
+	return p.out.close(ctx)
+}
+
+// flushTrace prints the buffered spans of a trace once its timeout expired,
+// unless they were printed already.
+func (p *Processor) flushTrace(traceID trace.TraceID, pend *pending) {
+	p.mu.Lock()
+	if p.traces[traceID] != pend {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.traces, traceID)
+	p.mu.Unlock()
+
+	p.write(buildTree(pend.spans))
+}
+
+func (p *Processor) write(roots []*node) {
+	var buf bytes.Buffer
+	for _, root := range roots {
+		for _, n := range p.prune(root) {
+			p.format.render(&buf, n)
+		}
+	}
+	if buf.Len() > 0 {
+		p.output(buf.Bytes())
+	}
+}
+
+// prune removes the spans filtered out from the tree rooted at n, moving
+// their children up to take their place.
+func (p *Processor) prune(n *node) []*node {
+	var children []*node
+	for _, c := range n.children {
+		children = append(children, p.prune(c)...)
+	}
+	sortByStart(children)
+
+	if !p.keep(n.span) {
+		return children
+	}
+	n.children = children
+	return []*node{n}
+}
+
+// keep reports whether the ended span s passes every filter.
+func (p *Processor) keep(s sdktrace.ReadOnlySpan) bool {
+	return p.filter.match(s) && p.filter.outcome(s)
+}
+
+func (p *Processor) output(b []byte) {
+	p.out.write(b)
+}
+
+// isLocalRoot reports whether s is the root of its trace in this process.
+func isLocalRoot(s sdktrace.ReadOnlySpan) bool {
+	return !s.Parent().IsValid() || s.Parent().IsRemote()
 }
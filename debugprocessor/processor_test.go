@@ -1,3 +1,577 @@
 package debugprocessor
 
-// TODO(wperron) Add some tests
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTree(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().WithWriter(&buf).WithQueueSize(0).WithTimeout(time.Minute).Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	cctx, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Int("depth", 1)))
+	_, grandchild := tracer.Start(cctx, "grandchild")
+	grandchild.AddEvent("retry")
+	grandchild.SetStatus(codes.Error, "failed")
+	grandchild.End()
+	child.End()
+	_, sibling := tracer.Start(ctx, "sibling")
+	sibling.End()
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be printed before the root ends, got %q", buf.String())
+	}
+	root.End()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	prefixes := []string{
+		"test::root{} ",
+		"├─ test::child{depth=1} +",
+		"│  └─ test::grandchild{} +",
+		"│        · retry{} +",
+		"└─ test::sibling{} +",
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(prefixes), len(lines), buf.String())
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+	if !strings.HasSuffix(lines[2], "error: failed") {
+		t.Errorf("expected grandchild to be failed, got %q", lines[2])
+	}
+	if len(p.traces) != 0 {
+		t.Errorf("expected no buffered trace, got %d", len(p.traces))
+	}
+}
+
+func TestRemoteParentEndedFirst(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().WithWriter(&buf).WithQueueSize(0).WithTimeout(time.Minute).Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	// An in-process client span propagating to a server span, ending first as
+	// it does when the call is cancelled.
+	ctx, caller := tracer.Start(context.Background(), "caller")
+	_, call := tracer.Start(ctx, "call")
+	sctx := trace.ContextWithRemoteSpanContext(context.Background(), call.SpanContext())
+	_, server := tracer.Start(sctx, "server")
+	call.End()
+	server.End()
+
+	if !strings.HasPrefix(buf.String(), "test::server{} ") {
+		t.Fatalf("expected server to be printed once ended, got %q", buf.String())
+	}
+	buf.Reset()
+
+	caller.End()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "test::caller{} ") || !strings.HasPrefix(lines[1], "└─ test::call{} +") {
+		t.Errorf("expected caller and call to be printed, got %q", buf.String())
+	}
+	if len(p.traces) != 0 {
+		t.Errorf("expected no buffered trace, got %d", len(p.traces))
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var buf syncBuffer
+	p := New().WithWriter(&buf).WithQueueSize(defaultQueueSize).WithTimeout(10 * time.Millisecond).Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(buf.String(), "test::child{}") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected child to be printed after the timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	root.End()
+	if err := p.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(buf.String(), "test::root{}") {
+		t.Errorf("expected root to be printed once ended, got %q", buf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFormat(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().
+		WithWriter(&buf).
+		WithQueueSize(0).
+		WithColors(ColorNever).
+		WithTimestamps(true).
+		WithIDs(true).
+		WithDurationUnit(time.Millisecond).
+		WithAttributeFilter([]string{"http.*", "depth"}, []string{"http.user_agent"}).
+		WithResource(true).
+		Build()
+	res := resource.NewSchemaless(attribute.String("service.name", "test-service"))
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p), sdktrace.WithResource(res)).Tracer("test")
+
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	_, span := tracer.Start(context.Background(), "root",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("http.method", "GET"),
+			attribute.String("http.user_agent", "curl"),
+			attribute.Int("depth", 0),
+			attribute.Int("secret", 42),
+		),
+	)
+	span.End(trace.WithTimestamp(start.Add(1500 * time.Microsecond)))
+
+	sc := span.SpanContext()
+	want := "2021-11-01T12:00:00.000Z test::root{http.method=GET, depth=0} 1.5ms resource{service.name=test-service} " +
+		"trace_id=" + sc.TraceID().String() + " span_id=" + sc.SpanID().String() + "\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\nwant %q\ngot  %q", want, buf.String())
+	}
+}
+
+func TestColors(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().WithWriter(&buf).WithQueueSize(0).WithColors(ColorAlways).Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "root", trace.WithSpanKind(trace.SpanKindServer))
+	span.SetStatus(codes.Error, "failed")
+	span.End()
+
+	if !strings.Contains(buf.String(), ansiCyan+"test::root"+ansiReset) {
+		t.Errorf("expected server span name in cyan, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), ansiRed+"error: failed"+ansiReset) {
+		t.Errorf("expected error status in red, got %q", buf.String())
+	}
+
+	if useColors(ColorAuto, &buf) {
+		t.Errorf("expected no colors when not writing to a terminal")
+	}
+}
+
+func TestLive(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().
+		WithWriter(&buf).
+		WithQueueSize(0).
+		WithColors(ColorNever).
+		WithTimestamps(false).
+		WithIDs(false).
+		WithDurationUnit(0).
+		WithAttributeFilter(nil, nil).
+		WithResource(false).
+		WithLive(true).
+		Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	if !strings.HasPrefix(buf.String(), "→ test::root{}") {
+		t.Fatalf("expected root to be printed as soon as it starts, got %q", buf.String())
+	}
+
+	linked := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x01},
+		SpanID:  trace.SpanID{0x02},
+	})
+	_, child := tracer.Start(ctx, "child", trace.WithLinks(trace.Link{SpanContext: linked}))
+	child.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", 2)))
+	child.End()
+	root.End()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	prefixes := []string{
+		"→ test::root{}",
+		"│  → test::child{} +",
+		"│  │  · retry{attempt=2} +",
+		"│  │  ↪ link{} trace_id=" + linked.TraceID().String() + " span_id=" + linked.SpanID().String(),
+		"│  ← test::child ",
+		"← test::root ",
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(prefixes), len(lines), buf.String())
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+	if len(p.active) != 0 {
+		t.Errorf("expected no active span left, got %d", len(p.active))
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	for _, enc := range []string{"json", "logfmt"} {
+		t.Run(enc, func(t *testing.T) {
+			encoding, err := ParseEncoding(enc)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var buf bytes.Buffer
+			p := New().
+				WithWriter(&buf).
+				WithQueueSize(0).
+				WithAttributeFilter(nil, []string{"secret"}).
+				WithLive(false).
+				WithEncoding(encoding).
+				Build()
+			res := resource.NewSchemaless(attribute.String("service.name", "test-service"))
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p), sdktrace.WithResource(res)).Tracer("test")
+
+			ctx, root := tracer.Start(context.Background(), "root")
+			_, child := tracer.Start(ctx, "child",
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attribute.String("http.method", "GET"), attribute.Int("secret", 42)),
+			)
+			child.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", 2)))
+			child.SetStatus(codes.Error, "failed")
+			child.End()
+
+			// Spans are printed as soon as they end.
+			line := buf.String()
+			if strings.Count(line, "\n") != 1 {
+				t.Fatalf("expected a single line, got %q", line)
+			}
+			root.End()
+
+			sc := child.SpanContext()
+			if enc == "logfmt" {
+				for _, want := range []string{
+					"trace_id=" + sc.TraceID().String(),
+					"span_id=" + sc.SpanID().String(),
+					"parent_id=" + root.SpanContext().SpanID().String(),
+					"name=child", "library=test", "kind=client", "status=Error", "status_message=failed",
+					"attr.http.method=GET", "event.0.name=retry", "event.0.attempt=2",
+					"resource.service.name=test-service",
+				} {
+					if !strings.Contains(line, want) {
+						t.Errorf("expected %q in %q", want, line)
+					}
+				}
+				if strings.Contains(line, "secret") {
+					t.Errorf("expected filtered attribute to be omitted, got %q", line)
+				}
+				return
+			}
+
+			var got struct {
+				TraceID    string                 `json:"trace_id"`
+				ParentID   string                 `json:"parent_id"`
+				Name       string                 `json:"name"`
+				Kind       string                 `json:"kind"`
+				Status     string                 `json:"status"`
+				DurationMS float64                `json:"duration_ms"`
+				Attributes map[string]interface{} `json:"attributes"`
+				Resource   map[string]interface{} `json:"resource"`
+				Events     []struct {
+					Name       string                 `json:"name"`
+					Attributes map[string]interface{} `json:"attributes"`
+				} `json:"events"`
+			}
+			if err := json.Unmarshal([]byte(line), &got); err != nil {
+				t.Fatalf("invalid JSON line %q: %s", line, err)
+			}
+			if got.TraceID != sc.TraceID().String() || got.ParentID != root.SpanContext().SpanID().String() {
+				t.Errorf("unexpected IDs in %q", line)
+			}
+			if got.Name != "child" || got.Kind != "client" || got.Status != "Error" {
+				t.Errorf("unexpected span fields in %q", line)
+			}
+			if got.Attributes["http.method"] != "GET" || got.Attributes["secret"] != nil {
+				t.Errorf("unexpected attributes %v", got.Attributes)
+			}
+			if got.Resource["service.name"] != "test-service" {
+				t.Errorf("unexpected resource %v", got.Resource)
+			}
+			if len(got.Events) != 1 || got.Events[0].Name != "retry" || got.Events[0].Attributes["attempt"] != float64(2) {
+				t.Errorf("unexpected events %+v", got.Events)
+			}
+		})
+	}
+
+	if _, err := ParseEncoding("xml"); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	var buf bytes.Buffer
+	p := New().
+		WithWriter(&buf).
+		WithQueueSize(0).
+		WithEncoding(EncodingText).
+		WithAttributeFilter(nil, nil).
+		WithNameFilter(nil, []string{"recurse*"}).
+		WithMinDuration(time.Millisecond).
+		WithAttributePredicate("depth", func(v attribute.Value) bool { return v.AsInt64() < 2 }).
+		Build()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+	start := time.Now()
+	at := func(ms int) trace.SpanEventOption {
+		return trace.WithTimestamp(start.Add(time.Duration(ms) * time.Millisecond))
+	}
+
+	ctx, root := tracer.Start(context.Background(), "root", at(0), trace.WithAttributes(attribute.Int("depth", 0)))
+	rctx, recurse := tracer.Start(ctx, "recurse", at(1), trace.WithAttributes(attribute.Int("depth", 1)))
+	_, kept := tracer.Start(rctx, "kept", at(2), trace.WithAttributes(attribute.Int("depth", 1)))
+	kept.End(at(10))
+	_, short := tracer.Start(rctx, "short", at(3), trace.WithAttributes(attribute.Int("depth", 1)))
+	short.End(at(3))
+	_, deep := tracer.Start(rctx, "deep", at(4), trace.WithAttributes(attribute.Int("depth", 2)))
+	deep.End(at(10))
+	recurse.End(at(11))
+	root.End(at(12))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	prefixes := []string{
+		"test::root{depth=0} ",
+		"└─ test::kept{depth=1} +",
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(prefixes), len(lines), buf.String())
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+
+	// Only errors
+	buf.Reset()
+	p = New().
+		WithWriter(&buf).
+		WithQueueSize(0).
+		WithNameFilter(nil, nil).
+		WithMinDuration(0).
+		WithErrorsOnly(true).
+		Build()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+	ctx, root = tracer.Start(context.Background(), "root")
+	_, failed := tracer.Start(ctx, "failed")
+	failed.SetStatus(codes.Error, "failed")
+	failed.End()
+	root.End()
+	if !strings.HasPrefix(buf.String(), "test::failed{} ") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("expected only the failed span, got %q", buf.String())
+	}
+
+	// Slashes in span and library names are matched by `*` too
+	buf.Reset()
+	p = New().
+		WithWriter(&buf).
+		WithQueueSize(0).
+		WithNameFilter([]string{"GET*"}, nil).
+		WithLibraryFilter([]string{"*otelhttp"}, []string{"go.opentelemetry.io/*/grpc"}).
+		Build()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p))
+	for _, lib := range []string{
+		"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp",
+		"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/grpc",
+		"test",
+	} {
+		for _, name := range []string{"GET /users/42", "POST /users"} {
+			_, s := tp.Tracer(lib).Start(context.Background(), name)
+			s.End()
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp::GET /users/42{} ") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("expected only the otelhttp GET span, got %q", buf.String())
+	}
+}
+
+func TestSampling(t *testing.T) {
+	every := newSampler(4, 0, time.Minute)
+	kept := 0
+	for i := 0; i < 400; i++ {
+		var id trace.TraceID
+		binary.BigEndian.PutUint64(id[8:], uint64(i))
+		if every.sample(id) {
+			kept++
+		}
+		if every.sample(id) != every.sample(id) {
+			t.Fatalf("expected sampling decisions to be consistent")
+		}
+	}
+	if kept != 100 {
+		t.Errorf("expected 1 in 4 traces to be kept, got %d out of 400", kept)
+	}
+
+	limited := newSampler(0, 2, time.Minute)
+	now := time.Now()
+	limited.now = func() time.Time { return now }
+	var ids []trace.TraceID
+	for i := 0; i < 3; i++ {
+		ids = append(ids, trace.TraceID{byte(i + 1)})
+	}
+	if !limited.sample(ids[0]) || !limited.sample(ids[1]) || limited.sample(ids[2]) {
+		t.Errorf("expected 2 traces per second to be kept")
+	}
+	// Later spans of a kept trace are kept even once out of tokens.
+	if !limited.sample(ids[0]) {
+		t.Errorf("expected decisions to be remembered")
+	}
+	now = now.Add(time.Second)
+	if !limited.sample(trace.TraceID{0xff}) {
+		t.Errorf("expected tokens to be refilled")
+	}
+
+	if newSampler(1, 0, time.Minute) != nil {
+		t.Errorf("expected no sampler when keeping every trace")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	var a, b bytes.Buffer
+	builder := New(WithWriter(&a), WithQueueSize(0))
+	pa := builder.Build()
+	pb := New().WithWriter(&b).WithQueueSize(0).Build()
+	// Changing the builder once built doesn't change the processor.
+	builder.WithAttributePredicate("never", func(attribute.Value) bool { return false })
+
+	for _, p := range []*Processor{pa, pb} {
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+		_, span := tracer.Start(context.Background(), "root")
+		span.End()
+	}
+	if strings.Count(a.String(), "test::root{}") != 1 || strings.Count(b.String(), "test::root{}") != 1 {
+		t.Errorf("expected each processor to write to its own writer, got %q and %q", a.String(), b.String())
+	}
+
+	p := NewProcessor(WithLive(true), WithEncoding(EncodingJSON), WithTimeout(time.Second))
+	if !p.live || p.encoding != EncodingJSON || p.timeout != time.Second {
+		t.Errorf("expected options to be applied, got %+v", p)
+	}
+	if New().live || New().encoding != EncodingText {
+		t.Errorf("expected new builders to use the defaults")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const (
+		goroutines = 8
+		traces     = 50
+	)
+	modes := []struct {
+		name     string
+		opts     []Option
+		perTrace int
+	}{
+		{"tree", nil, 3},
+		{"live", []Option{WithLive(true)}, 6},
+		{"json", []Option{WithEncoding(EncodingJSON)}, 3},
+		{"logfmt", []Option{WithEncoding(EncodingLogfmt)}, 3},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			var buf syncBuffer
+			opts := append([]Option{WithWriter(&buf), WithQueueSize(goroutines * traces * 6)}, mode.opts...)
+			p := NewProcessor(opts...)
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(p)).Tracer("test")
+
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < traces; j++ {
+						ctx, root := tracer.Start(context.Background(), "root")
+						for k := 0; k < 2; k++ {
+							_, child := tracer.Start(ctx, "child")
+							child.End()
+						}
+						root.End()
+					}
+				}()
+			}
+			wg.Wait()
+			if err := p.Shutdown(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if want := goroutines * traces * mode.perTrace; len(lines) != want {
+				t.Fatalf("expected %d lines, got %d", want, len(lines))
+			}
+			if p.Dropped() != 0 {
+				t.Errorf("expected no dropped line, got %d", p.Dropped())
+			}
+			for _, line := range lines {
+				if mode.name == "json" && !json.Valid([]byte(line)) {
+					t.Fatalf("expected every line to be valid JSON, got %q", line)
+				}
+				if !strings.Contains(line, "root") && !strings.Contains(line, "child") {
+					t.Fatalf("expected every line to be a whole span, got %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exp := New(WithWriter(&buf), WithQueueSize(0), WithLive(true)).BuildExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp))
+	tracer := tp.Tracer("test")
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	root.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "test::root{} ") || !strings.HasPrefix(lines[1], "└─ test::child{} +") {
+		t.Errorf("expected the trace to be printed as a tree, got %q", buf.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := exp.ExportSpans(ctx, []sdktrace.ReadOnlySpan{nil}); err == nil {
+		t.Errorf("expected an error once the context is done")
+	}
+}
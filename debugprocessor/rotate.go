@@ -0,0 +1,191 @@
+package debugprocessor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat sorts rotated files by age when sorted by name.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotateOpts configures when a RotatingFile is rotated.
+type RotateOpts struct {
+	// MaxSize is the size in bytes past which the file is rotated. 0 never
+	// rotates the file based on its size.
+	MaxSize int64
+	// MaxAge is how long a file is written to before being rotated. 0 never
+	// rotates the file based on its age.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files kept, removing the oldest
+	// ones. 0 keeps every rotated file.
+	MaxBackups int
+}
+
+// RotatingFile is an io.WriteCloser appending to a file, and renaming it
+// aside to start a new one once too large or too old. Rotated files are named
+// after the time of the rotation, e.g. traces-20211101T120000.000.log for
+// traces.log. Every write goes to a single file. A failed rotation is retried
+// on the next write, which goes to the current file in the meantime.
+type RotatingFile struct {
+	path   string
+	opts   RotateOpts
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+}
+
+// NewRotatingFile opens the file at path for appending, creating it if it
+// doesn't exist.
+func NewRotatingFile(path string, opts RotateOpts) (*RotatingFile, error) {
+	if opts.MaxSize < 0 || opts.MaxAge < 0 || opts.MaxBackups < 0 {
+		return nil, fmt.Errorf("rotation limits must not be negative")
+	}
+
+	r := &RotatingFile{path: filepath.Clean(path), opts: opts, now: time.Now, rename: os.Rename}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.f == nil {
+		// A previous rotation failed to reopen the file.
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size > 0 && r.expired(int64(len(p))) {
+		// Only fail the write if the rotation left no file to write to.
+		if err := r.rotate(); err != nil && r.f == nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file. Writes fail from then on.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// expired reports whether the file must be rotated before writing n more
+// bytes.
+func (r *RotatingFile) expired(n int64) bool {
+	if r.opts.MaxSize > 0 && r.size+n > r.opts.MaxSize {
+		return true
+	}
+	return r.opts.MaxAge > 0 && r.now().Sub(r.opened) >= r.opts.MaxAge
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening file %s: %s", r.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening file %s: %s", r.path, err)
+	}
+
+	r.f = f
+	r.size = info.Size()
+	r.opened = r.now()
+	return nil
+}
+
+// rotate renames the current file aside and opens a new one. If the file
+// can't be renamed, the current file is reopened instead. r.f is left nil if
+// no file could be opened.
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+	if err != nil {
+		return fmt.Errorf("closing file %s: %s", r.path, err)
+	}
+
+	prefix, ext := r.backupName()
+	backup := prefix + r.now().UTC().Format(backupTimeFormat) + ext
+	if err := r.rename(r.path, backup); err != nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+		return fmt.Errorf("rotating file %s: %s", r.path, err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.removeBackups()
+}
+
+// backupName returns the prefix and extension of rotated files.
+func (r *RotatingFile) backupName() (string, string) {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-", ext
+}
+
+// removeBackups removes the oldest rotated files past MaxBackups.
+func (r *RotatingFile) removeBackups() error {
+	if r.opts.MaxBackups == 0 {
+		return nil
+	}
+
+	prefix, ext := r.backupName()
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return fmt.Errorf("removing rotated files: %s", err)
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := filepath.Join(filepath.Dir(r.path), e.Name())
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > r.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("removing rotated files: %s", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package debugprocessor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "traces.log")
+	r, err := NewRotatingFile(path, RotateOpts{MaxSize: 10, MaxAge: time.Hour, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.opened = now
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		now = now.Add(time.Second)
+	}
+
+	write("12345\n")
+	// Too large for the current file.
+	write("1234\n")
+	write("abc\n")
+	// Lines larger than MaxSize are written to a file of their own.
+	write("a line too large\n")
+	// Too old.
+	now = now.Add(time.Hour)
+	write("new\n")
+
+	assertFile(t, path, "new\n")
+	backups, _ := filepath.Glob(filepath.Join(dir, "traces-*.log"))
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", backups)
+	}
+	assertFile(t, backups[0], "1234\nabc\n")
+	assertFile(t, backups[1], "a line too large\n")
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := r.Write([]byte("late\n")); err == nil {
+		t.Errorf("expected writes to fail once closed")
+	}
+
+	// Reopening appends to the existing file.
+	r, err = NewRotatingFile(path, RotateOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := r.Write([]byte("again\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r.Close()
+	assertFile(t, path, "new\nagain\n")
+
+	if _, err := NewRotatingFile(filepath.Join(dir, "missing", "traces.log"), RotateOpts{}); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
+
+func TestRotatingFileFailures(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "traces.log")
+	r, err := NewRotatingFile(path, RotateOpts{MaxSize: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+
+	write := func(s string) error {
+		_, err := r.Write([]byte(s))
+		return err
+	}
+
+	// Writes keep going to the current file while it can't be renamed.
+	r.rename = func(string, string) error { return os.ErrPermission }
+	for _, s := range []string{"abc\n", "def\n"} {
+		if err := write(s); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	assertFile(t, path, "abc\ndef\n")
+
+	// The file can't be reopened after being renamed, so the write fails.
+	r.rename = func(oldpath, newpath string) error {
+		if err := os.Rename(oldpath, newpath); err != nil {
+			return err
+		}
+		return os.Mkdir(oldpath, 0755)
+	}
+	if err := write("ghi\n"); err == nil {
+		t.Fatalf("expected an error reopening the file")
+	}
+
+	// The file is reopened on the next write.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	r.rename = os.Rename
+	if err := write("jkl\n"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertFile(t, path, "jkl\n")
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != want {
+		t.Errorf("expected %s to contain %q, got %q", filepath.Base(path), want, b)
+	}
+}
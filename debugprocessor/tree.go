@@ -0,0 +1,82 @@
+package debugprocessor
+
+import (
+	"sort"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// node is a span and its children, sorted by start time.
+type node struct {
+	span     sdktrace.ReadOnlySpan
+	children []*node
+}
+
+// buildTree links spans to their parents, returning the spans whose parent
+// isn't part of spans as roots.
+func buildTree(spans []sdktrace.ReadOnlySpan) []*node {
+	_, roots := link(spans)
+	return roots
+}
+
+// link links spans to their parents, returning every node by span ID along
+// with the roots.
+func link(spans []sdktrace.ReadOnlySpan) (map[trace.SpanID]*node, []*node) {
+	nodes := make(map[trace.SpanID]*node, len(spans))
+	for _, s := range spans {
+		nodes[s.SpanContext().SpanID()] = &node{span: s}
+	}
+
+	var roots []*node
+	for _, s := range spans {
+		n := nodes[s.SpanContext().SpanID()]
+		if parent, ok := nodes[s.Parent().SpanID()]; ok && s.Parent().IsValid() {
+			parent.children = append(parent.children, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+
+	for _, n := range nodes {
+		sortByStart(n.children)
+	}
+	sortByStart(roots)
+	return nodes, roots
+}
+
+// extract returns the tree rooted at the span with the given ID, and the
+// spans that aren't part of it. The span doesn't need to be a root of spans:
+// a span with a remote parent may still have that parent among them, when
+// the parent was started in this process too.
+func extract(spans []sdktrace.ReadOnlySpan, rootID trace.SpanID) (*node, []sdktrace.ReadOnlySpan) {
+	nodes, _ := link(spans)
+	root, ok := nodes[rootID]
+	if !ok {
+		return nil, spans
+	}
+
+	inTree := map[trace.SpanID]bool{}
+	var mark func(n *node)
+	mark = func(n *node) {
+		inTree[n.span.SpanContext().SpanID()] = true
+		for _, c := range n.children {
+			mark(c)
+		}
+	}
+	mark(root)
+
+	var rest []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if !inTree[s.SpanContext().SpanID()] {
+			rest = append(rest, s)
+		}
+	}
+	return root, rest
+}
+
+func sortByStart(nodes []*node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].span.StartTime().Before(nodes[j].span.StartTime())
+	})
+}
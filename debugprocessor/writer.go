@@ -0,0 +1,148 @@
+package debugprocessor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultQueueSize = 1024
+
+// lineWriter writes the output of a Processor. Writers own the slices passed
+// to write.
+type lineWriter interface {
+	write(b []byte)
+	// flush returns once everything written so far is out.
+	flush(ctx context.Context) error
+	// close flushes the writer, and ignores writes from then on.
+	close(ctx context.Context) error
+	dropped() uint64
+}
+
+// syncWriter writes to the output from the caller's goroutine, one write at
+// a time.
+type syncWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (w *syncWriter) write(b []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, _ = w.out.Write(b)
+}
+
+func (w *syncWriter) flush(ctx context.Context) error { return nil }
+func (w *syncWriter) close(ctx context.Context) error { return nil }
+func (w *syncWriter) dropped() uint64                 { return 0 }
+
+// asyncWriter writes to the output from its own goroutine, so that slow
+// outputs don't block the application. Writes are dropped when its queue is
+// full.
+type asyncWriter struct {
+	out   io.Writer
+	queue chan entry
+	done  chan struct{}
+
+	// drops counts the dropped writes, and is incremented along with
+	// droppedLines if not nil.
+	drops        uint64
+	droppedLines prometheus.Counter
+
+	// mu guards closed, so that nothing is sent on the queue once closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// entry is either a line to write, or a flush request to acknowledge once
+// every line queued before it is written.
+type entry struct {
+	b       []byte
+	flushed chan struct{}
+}
+
+func newAsyncWriter(out io.Writer, size int, droppedLines prometheus.Counter) *asyncWriter {
+	w := &asyncWriter{
+		out:          out,
+		queue:        make(chan entry, size),
+		done:         make(chan struct{}),
+		droppedLines: droppedLines,
+	}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		if e.flushed != nil {
+			close(e.flushed)
+			continue
+		}
+		_, _ = w.out.Write(e.b)
+	}
+}
+
+func (w *asyncWriter) write(b []byte) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.queue <- entry{b: b}:
+	default:
+		atomic.AddUint64(&w.drops, 1)
+		if w.droppedLines != nil {
+			w.droppedLines.Inc()
+		}
+	}
+}
+
+func (w *asyncWriter) flush(ctx context.Context) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return nil
+	}
+
+	flushed := make(chan struct{})
+	select {
+	case w.queue <- entry{flushed: flushed}:
+		w.mu.RUnlock()
+	case <-ctx.Done():
+		w.mu.RUnlock()
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *asyncWriter) close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *asyncWriter) dropped() uint64 {
+	return atomic.LoadUint64(&w.drops)
+}
@@ -0,0 +1,57 @@
+package debugprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	out := &blockingWriter{unblock: make(chan struct{})}
+	w := newAsyncWriter(out, 2, droppedLines(reg))
+
+	// The first line blocks the writer, the next 2 fill the queue.
+	for i := 0; i < 5; i++ {
+		w.write([]byte("line\n"))
+	}
+	if w.dropped() < 2 {
+		t.Errorf("expected lines to be dropped once the queue is full, got %d", w.dropped())
+	}
+	if got := testutil.ToFloat64(droppedLines(reg)); got != float64(w.dropped()) {
+		t.Errorf("expected the counter to match the dropped lines, got %v", got)
+	}
+
+	close(out.unblock)
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	written := out.String()
+	if want := 5 - int(w.dropped()); len(written) != want*len("line\n") {
+		t.Errorf("expected %d lines after flushing, got %q", want, written)
+	}
+
+	if err := w.close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w.write([]byte("late\n"))
+	if err := w.flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != written {
+		t.Errorf("expected writes to be ignored once closed, got %q", out.String())
+	}
+}
+
+// blockingWriter blocks every write until unblock is closed.
+type blockingWriter struct {
+	unblock chan struct{}
+	syncBuffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.syncBuffer.Write(p)
+}
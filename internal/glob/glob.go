@@ -0,0 +1,38 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package glob matches names against shell-like patterns. Unlike path.Match,
+// `*` also matches slashes, which span names like `GET /users` and library
+// names like `go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp`
+// are full of.
+package glob
+
+// Match reports whether name matches pattern, where `*` matches any sequence
+// of characters, including an empty one, and `?` matches any single
+// character. Every other character matches itself.
+func Match(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+
+	// Position of the last `*` in the pattern, and of the name when it was
+	// reached, to backtrack to when the rest of the pattern doesn't match.
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case star >= 0:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
@@ -0,0 +1,30 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"*", "GET /users", true},
+		{"GET*", "GET /users/42", true},
+		{"GET /users/*", "GET /users/42", true},
+		{"*otelhttp", "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp", true},
+		{"go.opentelemetry.io/*", "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp", true},
+		{"http.*", "http.method", true},
+		{"db.?ystem", "db.system", true},
+		{"*a*b", "xaxxbxb", true},
+		{"GET*", "POST /users", false},
+		{"*otelhttp", "go.opentelemetry.io/otel/sdk", false},
+		{"db.?", "db.system", false},
+		{"a*b", "a", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.name); got != c.want {
+			t.Errorf("Match(%q, %q) = %t, expected %t", c.pattern, c.name, got, c.want)
+		}
+	}
+}
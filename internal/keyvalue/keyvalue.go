@@ -0,0 +1,28 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package keyvalue parses the key=value lists passed to command line flags,
+// e.g. `-headers` or `-resource-attributes`.
+package keyvalue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parse parses a comma-separated list of key=value pairs. Whitespace around
+// keys and values is trimmed.
+func Parse(s string) (map[string]string, error) {
+	m := map[string]string{}
+	if s == "" {
+		return m, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m, nil
+}
@@ -0,0 +1,31 @@
+package keyvalue
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]map[string]string{
+		"":                     {},
+		"a=b":                  {"a": "b"},
+		" a = b , c=d=e,f=":    {"a": "b", "c": "d=e", "f": ""},
+		"authorization=Bearer": {"authorization": "Bearer"},
+	}
+	for s, want := range cases {
+		got, err := Parse(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %v, got %v", s, want, got)
+		}
+	}
+
+	for _, s := range []string{"a", "=b", "a=b,", "a=b,c"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package promutil holds the Prometheus helpers shared by the packages
+// exposing metrics.
+package promutil
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Register registers c with reg, returning the already registered collector
+// instead if there is one.
+func Register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, fmt.Errorf("registering metrics: %w", err)
+	}
+	return c, nil
+}
+
+// Exemplar returns the exemplar labels linking to the trace of sc, or nil if
+// sc isn't sampled and so won't be found in the tracing backend.
+func Exemplar(sc trace.SpanContext) prometheus.Labels {
+	if !sc.IsValid() || !sc.IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"traceID": sc.TraceID().String()}
+}
+
+// Add adds v to c, with exemplar attached if it isn't nil.
+func Add(c prometheus.Counter, v float64, exemplar prometheus.Labels) {
+	if ea, ok := c.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		ea.AddWithExemplar(v, exemplar)
+		return
+	}
+	c.Add(v)
+}
+
+// Observe observes v with o, with exemplar attached if it isn't nil.
+func Observe(o prometheus.Observer, v float64, exemplar prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(v, exemplar)
+		return
+	}
+	o.Observe(v)
+}
@@ -0,0 +1,36 @@
+package promutil
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "test_total", Help: "A test counter."}
+
+	first, err := Register(reg, prometheus.NewCounter(opts))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := Register(reg, prometheus.NewCounter(opts))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first != second {
+		t.Errorf("expected the registered counter to be reused")
+	}
+
+	Add(second.(prometheus.Counter), 2, prometheus.Labels{"traceID": "0af7651916cd43dd8448eb211c80319c"})
+	Add(second.(prometheus.Counter), 1, nil)
+	if got := testutil.ToFloat64(first.(prometheus.Counter)); got != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+
+	opts.Help = "A different help."
+	if _, err := Register(reg, prometheus.NewCounter(opts)); err == nil {
+		t.Errorf("expected an error registering an inconsistent counter")
+	}
+}
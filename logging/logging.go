@@ -0,0 +1,24 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package logging creates the go-kit loggers used by the commands.
+package logging
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-kit/kit/log"
+)
+
+// New returns a logger writing to out in the given format, either `logfmt`
+// or `json`. The logger is safe for concurrent use.
+func New(format string, out io.Writer) (log.Logger, error) {
+	switch format {
+	case "logfmt":
+		return log.NewLogfmtLogger(log.NewSyncWriter(out)), nil
+	case "json":
+		return log.NewJSONLogger(log.NewSyncWriter(out)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
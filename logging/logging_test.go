@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for format, want := range map[string]string{
+		"logfmt": "msg=hello\n",
+		"json":   "{\"msg\":\"hello\"}\n",
+	} {
+		var buf bytes.Buffer
+		l, err := New(format, &buf)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", format, err)
+		}
+		_ = l.Log("msg", "hello")
+		if buf.String() != want {
+			t.Errorf("%s: expected %q, got %q", format, want, buf.String())
+		}
+	}
+
+	if _, err := New("xml", &bytes.Buffer{}); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
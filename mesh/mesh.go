@@ -0,0 +1,396 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package mesh runs several virtual services in a single process. Each
+// service has its own service name and listens on its own address, and calls
+// the other services over HTTP according to a call graph, propagating the
+// trace context. This produces traces spanning several services, from which
+// backends can build a real service map.
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wperron/o11yutil/topology"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+// Config of the virtual services.
+type Config struct {
+	Services []Service `yaml:"services"`
+}
+
+// Service is a virtual service.
+type Service struct {
+	// Name of the service, used as its `service.name` resource attribute.
+	Name string `yaml:"name"`
+
+	// Addr the service listens on, e.g. `:8090`.
+	Addr string `yaml:"addr"`
+
+	// URL other services use to call this one. Defaults to
+	// `http://localhost` followed by the port of Addr.
+	URL string `yaml:"url,omitempty"`
+
+	// Duration of the work done by the service itself, expressed in
+	// milliseconds.
+	Duration topology.Distribution `yaml:"duration"`
+
+	// ErrorPercent of requests failing with a server error.
+	ErrorPercent float64 `yaml:"error_percent,omitempty"`
+
+	// Calls made to other services for every request, once the service's own
+	// work is done.
+	Calls []Call `yaml:"calls,omitempty"`
+}
+
+// Call to another service.
+type Call struct {
+	Service string `yaml:"service"`
+
+	// Path requested on the service. Defaults to `/`.
+	Path string `yaml:"path,omitempty"`
+
+	// Count of calls made to the service. Defaults to 1.
+	Count int `yaml:"count,omitempty"`
+
+	// Parallel calls are made concurrently rather than one after the other.
+	Parallel bool `yaml:"parallel,omitempty"`
+
+	// Percent chance of each call being made. Defaults to 100.
+	Percent *float64 `yaml:"percent,omitempty"`
+}
+
+func Load(s string) (*Config, error) {
+	cfg := &Config{}
+
+	if err := yaml.Unmarshal([]byte(s), cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadFile(fp string) (*Config, error) {
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %s", fp, err)
+	}
+
+	cfg, err := Load(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file: %s", err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that services are uniquely named, that every call targets
+// a known service, and that calls don't form a cycle, which would make
+// services call each other forever.
+func (c *Config) Validate() error {
+	names := make(map[string]bool, len(c.Services))
+	for _, s := range c.Services {
+		if s.Name == "" {
+			return errors.New("service without a name")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("duplicate service %s", s.Name)
+		}
+		if s.Addr == "" {
+			return fmt.Errorf("service %s: missing addr", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	for _, s := range c.Services {
+		if err := s.Duration.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", s.Name, err)
+		}
+		if s.ErrorPercent < 0 || s.ErrorPercent > 100 {
+			return fmt.Errorf("service %s: error_percent must be between 0 and 100", s.Name)
+		}
+		for _, call := range s.Calls {
+			if !names[call.Service] {
+				return fmt.Errorf("service %s: unknown called service %q", s.Name, call.Service)
+			}
+			if call.Percent != nil && (*call.Percent < 0 || *call.Percent > 100) {
+				return fmt.Errorf("service %s: percent of call to %s must be between 0 and 100", s.Name, call.Service)
+			}
+		}
+	}
+
+	return c.checkCycles()
+}
+
+// checkCycles walks the call graph depth first, failing on the first call
+// back to a service that is still being visited.
+func (c *Config) checkCycles() error {
+	calls := make(map[string][]Call, len(c.Services))
+	for _, s := range c.Services {
+		calls[s.Name] = s.Calls
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(c.Services))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("call cycle %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, call := range calls[name] {
+			if err := visit(call.Service, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for _, s := range c.Services {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// url returns the base URL used to reach the service.
+func (s Service) url() string {
+	if s.URL != "" {
+		return strings.TrimSuffix(s.URL, "/")
+	}
+	_, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return "http://" + s.Addr
+	}
+	return "http://localhost:" + port
+}
+
+// ProviderFunc returns the TracerProvider of the named service.
+type ProviderFunc func(service string) (trace.TracerProvider, error)
+
+// Mesh runs the virtual services.
+type Mesh struct {
+	services []*service
+}
+
+type service struct {
+	Service
+
+	tracer trace.Tracer
+	client *http.Client
+	urls   map[string]string
+	srv    *http.Server
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New creates the services described by cfg, each tracing with the
+// TracerProvider returned by newProvider and propagating the trace context
+// with propagator.
+func New(cfg *Config, newProvider ProviderFunc, propagator propagation.TextMapPropagator) (*Mesh, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]string, len(cfg.Services))
+	for _, s := range cfg.Services {
+		urls[s.Name] = s.url()
+	}
+
+	m := &Mesh{}
+	for _, s := range cfg.Services {
+		tp, err := newProvider(s.Name)
+		if err != nil {
+			return nil, fmt.Errorf("creating tracer provider for service %s: %w", s.Name, err)
+		}
+
+		svc := &service{
+			Service: s,
+			tracer:  tp.Tracer("github.com/wperron/o11yutil/mesh"),
+			client: &http.Client{
+				Transport: otelhttp.NewTransport(http.DefaultTransport,
+					otelhttp.WithTracerProvider(tp),
+					otelhttp.WithPropagators(propagator),
+				),
+				Timeout: 30 * time.Second,
+			},
+			urls: urls,
+			rnd:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		}
+		svc.srv = &http.Server{
+			Addr: s.Addr,
+			Handler: otelhttp.NewHandler(svc, s.Name,
+				otelhttp.WithTracerProvider(tp),
+				otelhttp.WithPropagators(propagator),
+			),
+		}
+		m.services = append(m.services, svc)
+	}
+
+	return m, nil
+}
+
+// ListenAndServe starts every service and blocks until one of them stops.
+func (m *Mesh) ListenAndServe() error {
+	errs := make(chan error, len(m.services))
+	for _, s := range m.services {
+		s := s
+		go func() {
+			if err := s.srv.ListenAndServe(); err != nil {
+				errs <- fmt.Errorf("service %s: %w", s.Name, err)
+			}
+		}()
+	}
+	return <-errs
+}
+
+// Shutdown gracefully stops every service.
+func (m *Mesh) Shutdown(ctx context.Context) error {
+	var errs []string
+	for _, s := range m.services {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("service %s: %s", s.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ServeHTTP does the service's own work, then calls the downstream services.
+// Requests fail if any downstream call fails.
+func (s *service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := s.tracer.Start(r.Context(), s.Name+".work")
+
+	s.mu.Lock()
+	dur := s.Duration.Sample(s.rnd)
+	fail := s.ErrorPercent > 0 && s.rnd.Float64()*100 < s.ErrorPercent
+	s.mu.Unlock()
+
+	span.SetAttributes(attribute.Int64("duration", dur.Milliseconds()))
+	select {
+	case <-ctx.Done():
+		span.End()
+		return
+	case <-time.After(dur):
+	}
+
+	if fail {
+		err := errors.New("injected error")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	span.End()
+
+	if err := s.callAll(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	fmt.Fprintf(w, "Hello from %s!", s.Name)
+}
+
+// callAll makes every configured call, returning the first error.
+func (s *service) callAll(ctx context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	record := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, c := range s.Calls {
+		count := c.Count
+		if count == 0 {
+			count = 1
+		}
+
+		for i := 0; i < count; i++ {
+			s.mu.Lock()
+			skip := c.Percent != nil && s.rnd.Float64()*100 >= *c.Percent
+			s.mu.Unlock()
+			if skip {
+				continue
+			}
+
+			if !c.Parallel {
+				if err := s.call(ctx, c); err != nil {
+					record(err)
+				}
+				continue
+			}
+
+			wg.Add(1)
+			go func(c Call) {
+				defer wg.Done()
+				if err := s.call(ctx, c); err != nil {
+					record(err)
+				}
+			}(c)
+		}
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+func (s *service) call(ctx context.Context, c Call) error {
+	path := c.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.urls[c.Service]+path, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling %s: %w", c.Service, err)
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	_ = res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("calling %s: %s", c.Service, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package mesh
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoad(t *testing.T) {
+	if _, err := LoadFile("../services.yaml"); err != nil {
+		t.Errorf("failed to load example services: %s", err)
+	}
+
+	invalid := map[string]string{
+		"unknown call": `
+services:
+  - name: a
+    addr: ":8090"
+    calls:
+      - service: b
+`,
+		"duplicate service": `
+services:
+  - name: a
+    addr: ":8090"
+  - name: a
+    addr: ":8091"
+`,
+		"missing addr": `
+services:
+  - name: a
+`,
+		"call cycle": `
+services:
+  - name: a
+    addr: ":8090"
+    calls:
+      - service: b
+  - name: b
+    addr: ":8091"
+    calls:
+      - service: a
+`,
+		"self call": `
+services:
+  - name: a
+    addr: ":8090"
+    calls:
+      - service: a
+`,
+	}
+	for name, s := range invalid {
+		if _, err := Load(s); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMesh(t *testing.T) {
+	conf, err := Load(`
+services:
+  - name: frontend
+    addr: ":8090"
+    calls:
+      - service: backend
+        count: 2
+        parallel: true
+  - name: backend
+    addr: ":8091"
+`)
+	if err != nil {
+		t.Fatalf("failed to load services: %s", err)
+	}
+
+	recorders := map[string]*tracetest.SpanRecorder{}
+	m, err := New(conf, func(name string) (trace.TracerProvider, error) {
+		rec := tracetest.NewSpanRecorder()
+		recorders[name] = rec
+		return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)), nil
+	}, propagation.TraceContext{})
+	if err != nil {
+		t.Fatalf("failed to create mesh: %s", err)
+	}
+
+	// Serve every service on a test server rather than its configured
+	// address.
+	for _, s := range m.services {
+		srv := httptest.NewServer(s.srv.Handler)
+		defer srv.Close()
+		for _, other := range m.services {
+			other.urls[s.Name] = srv.URL
+		}
+	}
+
+	res, err := http.Get(m.services[0].urls["frontend"])
+	if err != nil {
+		t.Fatalf("failed to call frontend: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", res.Status)
+	}
+
+	frontend := recorders["frontend"].Ended()
+	backend := recorders["backend"].Ended()
+
+	var traceID trace.TraceID
+	clients := 0
+	for _, s := range frontend {
+		traceID = s.SpanContext().TraceID()
+		if s.SpanKind() == trace.SpanKindClient {
+			clients++
+		}
+	}
+	if clients != 2 {
+		t.Errorf("expected 2 client spans from frontend, got %d", clients)
+	}
+
+	servers := 0
+	for _, s := range backend {
+		if s.SpanContext().TraceID() != traceID {
+			t.Errorf("expected backend spans to be part of the frontend trace")
+		}
+		if s.SpanKind() == trace.SpanKindServer {
+			servers++
+		}
+	}
+	if servers != 2 {
+		t.Errorf("expected 2 server spans in backend, got %d", servers)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/internal/promutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetricsOpts configures the metrics created by NewMetrics. The zero value
+// creates the metrics with their default names and buckets.
+type MetricsOpts struct {
+	// Namespace and Subsystem are prepended to every metric name.
+	Namespace string
+	Subsystem string
+
+	// ConstLabels are added to every metric.
+	ConstLabels prometheus.Labels
+
+	// DurationBuckets of the request duration histogram, in seconds. Defaults
+	// to prometheus.DefBuckets.
+	DurationBuckets []float64
+
+	// SizeBuckets of the request and response size histograms, in bytes.
+	// Defaults to powers of 4 from 64B to 4MiB.
+	SizeBuckets []float64
+}
+
+// Metrics holds the Prometheus collectors measuring the rate, errors and
+// duration (RED) of requests by route. A single Metrics can instrument any
+// number of routes.
+type Metrics struct {
+	InFlight     *prometheus.GaugeVec
+	Requests     *prometheus.CounterVec
+	Duration     *prometheus.HistogramVec
+	RequestSize  *prometheus.HistogramVec
+	ResponseSize *prometheus.HistogramVec
+}
+
+// NewMetrics creates the server metrics and registers them with reg. If
+// identical metrics are already registered with reg, those are reused.
+func NewMetrics(reg prometheus.Registerer, opts MetricsOpts) (*Metrics, error) {
+	if opts.DurationBuckets == nil {
+		opts.DurationBuckets = prometheus.DefBuckets
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = prometheus.ExponentialBuckets(64, 4, 9)
+	}
+
+	m := &Metrics{
+		InFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "api_requests_in_flight",
+				Help:        "A gauge for the number of in-flight requests.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"route"},
+		),
+
+		Requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "api_requests_total",
+				Help:        "A counter for requests to the api.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"route", "method", "status_class"},
+		),
+
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "api_requests_latency",
+				Help:        "A histogram for api response latencies.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.DurationBuckets,
+			},
+			[]string{"route", "method", "status_class"},
+		),
+
+		RequestSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "api_request_size_bytes",
+				Help:        "A histogram for api request body sizes.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.SizeBuckets,
+			},
+			[]string{"route", "method"},
+		),
+
+		ResponseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   opts.Subsystem,
+				Name:        "api_response_size_bytes",
+				Help:        "A histogram for api response body sizes.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.SizeBuckets,
+			},
+			[]string{"route", "method", "status_class"},
+		),
+	}
+
+	c, err := promutil.Register(reg, m.InFlight)
+	if err != nil {
+		return nil, err
+	}
+	m.InFlight = c.(*prometheus.GaugeVec)
+
+	if c, err = promutil.Register(reg, m.Requests); err != nil {
+		return nil, err
+	}
+	m.Requests = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, m.Duration); err != nil {
+		return nil, err
+	}
+	m.Duration = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, m.RequestSize); err != nil {
+		return nil, err
+	}
+	m.RequestSize = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, m.ResponseSize); err != nil {
+		return nil, err
+	}
+	m.ResponseSize = c.(*prometheus.HistogramVec)
+
+	return m, nil
+}
+
+// Handler instruments next, labelling its metrics with route. The route
+// should be the pattern the handler is registered with rather than the
+// request path, to keep the cardinality of the metrics bounded. Every sample
+// carries the ID of the current trace as an exemplar when it is sampled.
+func (m *Metrics) Handler(route string, next http.Handler) http.Handler {
+	inFlight := m.InFlight.WithLabelValues(route)
+
+	recorded := Record(next, func(r *http.Request, rec *Recorder) {
+		exemplar := promutil.Exemplar(trace.SpanContextFromContext(r.Context()))
+		class := statusClass(rec.Status)
+
+		promutil.Add(m.Requests.WithLabelValues(route, r.Method, class), 1, exemplar)
+		promutil.Observe(m.Duration.WithLabelValues(route, r.Method, class), rec.Duration().Seconds(), exemplar)
+		promutil.Observe(m.ResponseSize.WithLabelValues(route, r.Method, class), float64(rec.Written), exemplar)
+		if body, ok := r.Body.(*countingReader); ok {
+			promutil.Observe(m.RequestSize.WithLabelValues(route, r.Method), float64(body.size(r.ContentLength)), exemplar)
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		if r.Body != nil {
+			r.Body = &countingReader{ReadCloser: r.Body}
+		}
+		recorded.ServeHTTP(w, r)
+	})
+}
+
+// statusClass returns the class of a status code, e.g. 2xx, or unknown when
+// no status was sent, like on hijacked connections.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// size of the body, from its length when the handler didn't read it all.
+func (c *countingReader) size(contentLength int64) int64 {
+	if contentLength > c.n {
+		return contentLength
+	}
+	return c.n
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg, MetricsOpts{})
+	if err != nil {
+		t.Fatalf("failed to create metrics: %s", err)
+	}
+
+	// Metrics can be shared by several callers of the same registry.
+	if _, err := NewMetrics(reg, MetricsOpts{}); err != nil {
+		t.Fatalf("failed to reuse metrics: %s", err)
+	}
+
+	h := m.Handler("/items/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			http.Error(w, "nope", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	traceID := trace.TraceID{0x01, 0x02, 0x03}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	for _, path := range []string{"/items/1", "/items/2"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = req.WithContext(trace.ContextWithSpanContext(req.Context(), sc))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items/3", strings.NewReader("payload")))
+
+	if got := testutil.ToFloat64(m.Requests.WithLabelValues("/items/", http.MethodGet, "2xx")); got != 2 {
+		t.Errorf("expected 2 successful requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.Requests.WithLabelValues("/items/", http.MethodPost, "4xx")); got != 1 {
+		t.Errorf("expected 1 failed request, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.InFlight.WithLabelValues("/items/")); got != 0 {
+		t.Errorf("expected no request in flight, got %v", got)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+
+	for _, mf := range mfs {
+		var metric *dto.Metric
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "method" && l.GetValue() == http.MethodGet {
+					metric = m
+				}
+			}
+		}
+
+		var exemplars []*dto.Exemplar
+		switch mf.GetName() {
+		case "api_requests_total":
+			exemplars = append(exemplars, metric.GetCounter().GetExemplar())
+		case "api_requests_latency", "api_request_size_bytes", "api_response_size_bytes":
+			for _, b := range metric.GetHistogram().GetBucket() {
+				if b.GetExemplar() != nil {
+					exemplars = append(exemplars, b.GetExemplar())
+				}
+			}
+		default:
+			continue
+		}
+
+		if len(exemplars) == 0 || exemplars[0] == nil {
+			t.Errorf("expected an exemplar on %s", mf.GetName())
+			continue
+		}
+		if l := exemplars[0].GetLabel()[0]; l.GetName() != "traceID" || l.GetValue() != traceID.String() {
+			t.Errorf("expected traceID exemplar on %s, got %s=%s", mf.GetName(), l.GetName(), l.GetValue())
+		}
+
+		if mf.GetName() == "api_request_size_bytes" {
+			for _, m := range mf.GetMetric() {
+				if m != metric && m.GetHistogram().GetSampleSum() != float64(len("payload")) {
+					t.Errorf("expected request size of %d, got %v", len("payload"), m.GetHistogram().GetSampleSum())
+				}
+			}
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, want := range map[int]string{0: "unknown", 200: "2xx", 302: "3xx", 404: "4xx", 503: "5xx"} {
+		if got := statusClass(code); got != want {
+			t.Errorf("expected %s for %d, got %s", want, code, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package middleware provides HTTP middleware recording what handlers write
+// to the response.
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// Recorder holds the status, size and timing of a response.
+type Recorder struct {
+	// Status code sent to the client. Handlers writing a body without calling
+	// WriteHeader send an implicit 200, which is recorded as well.
+	Status int
+
+	// Written is the number of bytes of the response body.
+	Written int64
+
+	// Start is the time the request started being handled.
+	Start time.Time
+
+	// FirstByte is the time the header was written, or the zero time if it
+	// wasn't.
+	FirstByte time.Time
+
+	// End is the time the handler returned.
+	End time.Time
+
+	// Hijacked is true if the handler took over the connection.
+	Hijacked bool
+}
+
+// WroteHeader reports whether the header was sent to the client.
+func (r *Recorder) WroteHeader() bool {
+	return !r.FirstByte.IsZero()
+}
+
+// Duration of the request, up to now if the handler hasn't returned yet.
+func (r *Recorder) Duration() time.Duration {
+	if r.End.IsZero() {
+		return time.Since(r.Start)
+	}
+	return r.End.Sub(r.Start)
+}
+
+// TimeToFirstByte is the time it took to write the header.
+func (r *Recorder) TimeToFirstByte() time.Duration {
+	if r.FirstByte.IsZero() {
+		return 0
+	}
+	return r.FirstByte.Sub(r.Start)
+}
+
+func (r *Recorder) writeHeader(code int) {
+	if r.WroteHeader() {
+		return
+	}
+	r.Status = code
+	r.FirstByte = time.Now()
+}
+
+// Wrap returns a ResponseWriter delegating to w and recording the response.
+// The returned writer implements exactly the same optional interfaces as w,
+// among http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom.
+func Wrap(w http.ResponseWriter) (http.ResponseWriter, *Recorder) {
+	rec := &Recorder{Start: time.Now()}
+
+	return httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				// Informational responses are followed by the final one.
+				if code >= 200 || code == http.StatusSwitchingProtocols {
+					rec.writeHeader(code)
+				}
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				rec.writeHeader(http.StatusOK)
+				n, err := next(b)
+				rec.Written += int64(n)
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				rec.writeHeader(http.StatusOK)
+				n, err := next(src)
+				rec.Written += n
+				return n, err
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				rec.writeHeader(http.StatusOK)
+				next()
+			}
+		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				conn, rw, err := next()
+				if err == nil {
+					rec.Hijacked = true
+				}
+				return conn, rw, err
+			}
+		},
+	}), rec
+}
+
+// Record calls next with a recording ResponseWriter, then calls done with
+// the record of the response. done is called as well if next panics, before
+// the panic goes on; the response is then recorded as a 500 unless a status
+// was sent already.
+func Record(next http.Handler, done func(r *http.Request, rec *Recorder)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw, rec := Wrap(w)
+		panicked := true
+		defer func() {
+			rec.End = time.Now()
+			if !rec.WroteHeader() && !rec.Hijacked {
+				if panicked {
+					rec.Status = http.StatusInternalServerError
+				} else {
+					// net/http sends a 200 for handlers writing nothing.
+					rec.Status = http.StatusOK
+				}
+			}
+			done(r, rec)
+		}()
+		next.ServeHTTP(rw, r)
+		panicked = false
+	})
+}
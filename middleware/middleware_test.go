@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		written int64
+	}{
+		{
+			name:    "implicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("hello")) },
+			status:  http.StatusOK,
+			written: 5,
+		},
+		{
+			name:    "empty response",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			status:  http.StatusOK,
+		},
+		{
+			name: "explicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			status:  http.StatusServiceUnavailable,
+			written: int64(len("unavailable\n")),
+		},
+		{
+			name: "superfluous status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.WriteHeader(http.StatusOK)
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "informational status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusContinue)
+				w.WriteHeader(http.StatusAccepted)
+			},
+			status: http.StatusAccepted,
+		},
+		{
+			name: "read from",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := w.(io.ReaderFrom); !ok {
+					t.Errorf("expected writer to implement io.ReaderFrom")
+				}
+				_, _ = io.Copy(w, strings.NewReader("hello, world"))
+			},
+			status:  http.StatusOK,
+			written: 12,
+		},
+		{
+			name: "flush",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded := make(chan *Recorder, 1)
+			srv := httptest.NewServer(Record(tt.handler, func(r *http.Request, rec *Recorder) {
+				recorded <- rec
+			}))
+			defer srv.Close()
+
+			res, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			_, _ = io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+
+			got := <-recorded
+			if res.StatusCode != tt.status {
+				t.Errorf("expected client to get %d, got %d", tt.status, res.StatusCode)
+			}
+			if got.Status != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, got.Status)
+			}
+			if got.Written != tt.written {
+				t.Errorf("expected %d bytes written, got %d", tt.written, got.Written)
+			}
+			if got.End.Before(got.Start) || got.Duration() < got.TimeToFirstByte() {
+				t.Errorf("inconsistent timing: %+v", got)
+			}
+		})
+	}
+}
+
+func TestRecordPanic(t *testing.T) {
+	var got *Recorder
+	h := Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), func(r *http.Request, rec *Recorder) {
+		got = rec
+	})
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected the panic to go on, got %v", v)
+		}
+		if got == nil {
+			t.Fatalf("expected the response to be recorded")
+		}
+		if got.Status != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, got.Status)
+		}
+		if got.End.IsZero() {
+			t.Errorf("expected end time to be recorded")
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestWrapInterfaces(t *testing.T) {
+	w, _ := Wrap(httptest.NewRecorder())
+	if _, ok := w.(http.Hijacker); ok {
+		t.Errorf("expected wrapped writer not to implement http.Hijacker")
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		t.Errorf("expected wrapped writer to implement http.Flusher")
+	}
+}
+
+func TestHijack(t *testing.T) {
+	recorded := make(chan *Recorder, 1)
+	srv := httptest.NewServer(Record(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 204 No Content\r\n\r\n")
+		_ = rw.Flush()
+	}), func(r *http.Request, rec *Recorder) {
+		recorded <- rec
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+
+	got := <-recorded
+	if !got.Hijacked {
+		t.Errorf("expected response to be recorded as hijacked")
+	}
+	if got.WroteHeader() {
+		t.Errorf("expected no header to be recorded")
+	}
+}
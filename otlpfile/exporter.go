@@ -0,0 +1,124 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+package otlpfile
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ otlptrace.Client = &Client{}
+
+// Client is an implementation of otlptrace.Client writing every batch of
+// spans as an OTLP message. It's safe for concurrent use.
+type Client struct {
+	format Format
+
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	stopped bool
+}
+
+// NewClient creates a Client writing to w.
+func NewClient(w io.Writer, format Format) *Client {
+	return &Client{format: format, w: w}
+}
+
+// New creates an exporter writing to w.
+func New(w io.Writer, format Format) *otlptrace.Exporter {
+	return newExporter(NewClient(w, format))
+}
+
+// Create creates an exporter writing to the file at path, truncating it if
+// it exists. The file is closed when the exporter shuts down.
+func Create(path string, format Format) (*otlptrace.Exporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating file %s: %s", path, err)
+	}
+	return newExporter(&Client{format: format, w: f, closer: f}), nil
+}
+
+// newExporter wraps c in a started exporter, which never fails as there is
+// no connection to establish.
+func newExporter(c *Client) *otlptrace.Exporter {
+	exp := otlptrace.NewUnstarted(c)
+	_ = exp.Start(context.Background())
+	return exp
+}
+
+// Start implements otlptrace.Client. There is nothing to connect to.
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop stops writing, and closes the file opened by Create.
+func (c *Client) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	c.stopped = true
+
+	if c.closer != nil {
+		return c.closer.Close()
+	}
+	return nil
+}
+
+// UploadTraces writes spans as a single message. Spans uploaded after Stop
+// are ignored.
+func (c *Client) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	b, err := encode(&coltracepb.ExportTraceServiceRequest{ResourceSpans: spans}, c.format)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(b); err != nil {
+		return fmt.Errorf("writing spans: %s", err)
+	}
+	return nil
+}
+
+// encode returns req framed for the format: a line of JSON, or the
+// protobuf message prefixed with its size.
+func encode(req *coltracepb.ExportTraceServiceRequest, format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		b, err := protojson.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("encoding spans: %s", err)
+		}
+		return append(b, '\n'), nil
+	case FormatProto:
+		b, err := proto.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("encoding spans: %s", err)
+		}
+		size := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(size, uint64(len(b)))
+		return append(size[:n], b...), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
@@ -0,0 +1,154 @@
+package otlpfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestExporter(t *testing.T) {
+	for _, format := range []Format{FormatJSON, FormatProto} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			exp := New(&buf, format)
+			res := resource.NewSchemaless(attribute.String("service.name", "test-service"))
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp), sdktrace.WithResource(res))
+			tracer := tp.Tracer("test")
+
+			start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+			ctx, root := tracer.Start(context.Background(), "root", trace.WithTimestamp(start))
+			_, child := tracer.Start(ctx, "child",
+				trace.WithTimestamp(start.Add(time.Millisecond)),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attribute.Int("depth", 1), attribute.StringSlice("tags", []string{"a", "b"})),
+			)
+			child.AddEvent("retry", trace.WithTimestamp(start.Add(2*time.Millisecond)))
+			child.SetStatus(codes.Error, "failed")
+			child.End(trace.WithTimestamp(start.Add(3 * time.Millisecond)))
+			root.End(trace.WithTimestamp(start.Add(4 * time.Millisecond)))
+			if err := tp.Shutdown(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			r := NewReader(&buf, format)
+			var spans []*tracepb.Span
+			for {
+				req, err := r.Read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				rs := req.ResourceSpans[0]
+				if got := rs.Resource.Attributes[0].Value.GetStringValue(); got != "test-service" {
+					t.Errorf("expected the resource to be kept, got %q", got)
+				}
+				if got := rs.InstrumentationLibrarySpans[0].InstrumentationLibrary.Name; got != "test" {
+					t.Errorf("expected the instrumentation library to be kept, got %q", got)
+				}
+				spans = append(spans, rs.InstrumentationLibrarySpans[0].Spans...)
+			}
+			if len(spans) != 2 {
+				t.Fatalf("expected 2 spans, got %d", len(spans))
+			}
+
+			got := spans[0]
+			traceID, spanID := child.SpanContext().TraceID(), child.SpanContext().SpanID()
+			if !bytes.Equal(got.TraceId, traceID[:]) || !bytes.Equal(got.SpanId, spanID[:]) {
+				t.Errorf("expected the span IDs to be kept, got %x %x", got.TraceId, got.SpanId)
+			}
+			parent := root.SpanContext().SpanID()
+			if !bytes.Equal(got.ParentSpanId, parent[:]) {
+				t.Errorf("expected the parent to be kept, got %x", got.ParentSpanId)
+			}
+			if got.Name != "child" || got.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+				t.Errorf("unexpected span %s of kind %s", got.Name, got.Kind)
+			}
+			if got.Status.Code != tracepb.Status_STATUS_CODE_ERROR || got.Status.Message != "failed" {
+				t.Errorf("unexpected status %v", got.Status)
+			}
+			if len(got.Attributes) != 2 || len(got.Attributes[1].Value.GetArrayValue().Values) != 2 {
+				t.Errorf("unexpected attributes %v", got.Attributes)
+			}
+			if len(got.Events) != 1 || got.Events[0].Name != "retry" {
+				t.Errorf("unexpected events %v", got.Events)
+			}
+			if time.Unix(0, int64(got.StartTimeUnixNano)).Sub(start) != time.Millisecond {
+				t.Errorf("expected the start time to be kept, got %d", got.StartTimeUnixNano)
+			}
+		})
+	}
+}
+
+func TestShift(t *testing.T) {
+	var buf bytes.Buffer
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(New(&buf, FormatProto)))
+	start := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	_, span := tp.Tracer("test").Start(context.Background(), "root", trace.WithTimestamp(start))
+	span.AddEvent("retry", trace.WithTimestamp(start.Add(time.Second)))
+	span.End(trace.WithTimestamp(start.Add(2 * time.Second)))
+
+	req, err := NewReader(&buf, FormatProto).Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !Earliest(req).Equal(start) {
+		t.Errorf("expected the earliest span to start at %s, got %s", start, Earliest(req))
+	}
+
+	Shift(req, time.Hour)
+	s := req.ResourceSpans[0].InstrumentationLibrarySpans[0].Spans[0]
+	if !Earliest(req).Equal(start.Add(time.Hour)) {
+		t.Errorf("expected the span to be shifted by an hour, got %s", Earliest(req))
+	}
+	if s.EndTimeUnixNano-s.StartTimeUnixNano != uint64(2*time.Second) {
+		t.Errorf("expected the duration to be kept")
+	}
+	if s.Events[0].TimeUnixNano-s.StartTimeUnixNano != uint64(time.Second) {
+		t.Errorf("expected the event to be shifted along")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		name, path string
+		want       Format
+	}{
+		{"", "spans.json", FormatJSON},
+		{"", "spans.JSONL", FormatJSON},
+		{"", "spans.pb", FormatProto},
+		{"json", "spans.pb", FormatJSON},
+	}
+	for _, c := range cases {
+		got, err := ParseFormat(c.name, c.path)
+		if err != nil || got != c.want {
+			t.Errorf("ParseFormat(%q, %q) = %q, %v, want %q", c.name, c.path, got, err, c.want)
+		}
+	}
+	if _, err := ParseFormat("xml", ""); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(New(&buf, FormatProto)))
+	_, span := tp.Tracer("test").Start(context.Background(), "root")
+	span.End()
+
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err := NewReader(bytes.NewReader(truncated), FormatProto).Read(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package otlpfile records spans to a local file in the OTLP format, and
+// reads them back to be replayed to a collector later on.
+//
+// Files are a sequence of ExportTraceServiceRequest messages, one per
+// exported batch. In JSON, each message is a single line, as sent to the
+// OTLP/HTTP JSON endpoint. In protobuf, each message is prefixed with its
+// size as a varint.
+package otlpfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the encoding of an OTLP file.
+type Format string
+
+const (
+	FormatJSON  Format = "json"
+	FormatProto Format = "proto"
+)
+
+// ParseFormat returns the Format named s. An empty s picks the format from
+// the extension of path: JSON for .json and .jsonl files, protobuf
+// otherwise.
+func ParseFormat(s, path string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatJSON, FormatProto:
+		return f, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".jsonl":
+			return FormatJSON, nil
+		default:
+			return FormatProto, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+package otlpfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxMessageSize guards against reading a corrupted size prefix.
+const maxMessageSize = 64 << 20
+
+// Reader reads back the messages written by a Client.
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+}
+
+// NewReader creates a Reader of the messages in r.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{r: bufio.NewReader(r), format: format}
+}
+
+// Read returns the next message, or io.EOF once every message was read.
+func (r *Reader) Read() (*coltracepb.ExportTraceServiceRequest, error) {
+	switch r.format {
+	case FormatJSON:
+		return r.readJSON()
+	case FormatProto:
+		return r.readProto()
+	default:
+		return nil, fmt.Errorf("unknown format %q", r.format)
+	}
+}
+
+func (r *Reader) readJSON() (*coltracepb.ExportTraceServiceRequest, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := protojson.Unmarshal(line, req); err != nil {
+			return nil, fmt.Errorf("decoding spans: %s", err)
+		}
+		return req, nil
+	}
+}
+
+func (r *Reader) readProto() (*coltracepb.ExportTraceServiceRequest, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading message size: %s", err)
+	}
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is too large", size)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("reading message: %w", io.ErrUnexpectedEOF)
+	}
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(b, req); err != nil {
+		return nil, fmt.Errorf("decoding spans: %s", err)
+	}
+	return req, nil
+}
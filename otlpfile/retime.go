@@ -0,0 +1,51 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+package otlpfile
+
+import (
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// Earliest returns the start time of the earliest span in req, or the zero
+// time if req has no span.
+func Earliest(req *coltracepb.ExportTraceServiceRequest) time.Time {
+	var earliest uint64
+	for _, rs := range req.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, s := range ils.Spans {
+				if earliest == 0 || s.StartTimeUnixNano < earliest {
+					earliest = s.StartTimeUnixNano
+				}
+			}
+		}
+	}
+	if earliest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(earliest))
+}
+
+// Shift moves the spans of req and their events by d, keeping their
+// durations and the time between them.
+func Shift(req *coltracepb.ExportTraceServiceRequest, d time.Duration) {
+	shift := func(t uint64) uint64 {
+		if t == 0 {
+			return 0
+		}
+		return uint64(int64(t) + int64(d))
+	}
+
+	for _, rs := range req.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, s := range ils.Spans {
+				s.StartTimeUnixNano = shift(s.StartTimeUnixNano)
+				s.EndTimeUnixNano = shift(s.EndTimeUnixNano)
+				for _, e := range s.Events {
+					e.TimeUnixNano = shift(e.TimeUnixNano)
+				}
+			}
+		}
+	}
+}
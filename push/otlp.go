@@ -0,0 +1,242 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package push
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const instrumentationName = "github.com/wperron/o11yutil/push"
+
+// OTLPConfig configures an OTLPExporter.
+type OTLPConfig struct {
+	// Endpoint of the OTLP gRPC receiver, e.g. `localhost:4317`.
+	Endpoint string
+
+	// Insecure disables TLS on the connection to the receiver.
+	Insecure bool
+
+	// Headers added to every export request.
+	Headers map[string]string
+
+	// Resource describing the process the metrics are coming from.
+	Resource *resource.Resource
+}
+
+// OTLPExporter pushes metrics to an OpenTelemetry collector over OTLP gRPC.
+type OTLPExporter struct {
+	conn     *grpc.ClientConn
+	client   collectorpb.MetricsServiceClient
+	headers  metadata.MD
+	resource *resourcepb.Resource
+	start    time.Time
+}
+
+var _ Exporter = &OTLPExporter{}
+
+// NewOTLPExporter creates an OTLPExporter. The connection is established
+// lazily so that the receiver doesn't need to be up yet.
+func NewOTLPExporter(ctx context.Context, cfg OTLPConfig) (*OTLPExporter, error) {
+	creds := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	if cfg.Insecure {
+		creds = grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
+
+	conn, err := grpc.DialContext(ctx, cfg.Endpoint, creds)
+	if err != nil {
+		return nil, fmt.Errorf("dialing OTLP endpoint %s: %w", cfg.Endpoint, err)
+	}
+
+	return &OTLPExporter{
+		conn:     conn,
+		client:   collectorpb.NewMetricsServiceClient(conn),
+		headers:  metadata.New(cfg.Headers),
+		resource: toResource(cfg.Resource),
+		start:    time.Now(),
+	}, nil
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, mfs []*dto.MetricFamily) error {
+	ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	_, err := e.client.Export(ctx, &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: e.resource,
+				InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{
+					{
+						InstrumentationLibrary: &commonpb.InstrumentationLibrary{
+							Name: instrumentationName,
+						},
+						Metrics: toOTLPMetrics(mfs, e.start, time.Now()),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("otlp export: %w", err)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return e.conn.Close()
+}
+
+// toOTLPMetrics converts Prometheus metric families to OTLP metrics. Counters
+// and histograms are reported as cumulative since start.
+func toOTLPMetrics(mfs []*dto.MetricFamily, start, now time.Time) []*metricspb.Metric {
+	startNano, nowNano := uint64(start.UnixNano()), uint64(now.UnixNano())
+	metrics := make([]*metricspb.Metric, 0, len(mfs))
+
+	for _, mf := range mfs {
+		m := &metricspb.Metric{
+			Name:        mf.GetName(),
+			Description: mf.GetHelp(),
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, metric := range mf.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        toAttributes(metric.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: metric.GetCounter().GetValue()},
+				})
+			}
+			m.Data = &metricspb.Metric_Sum{Sum: sum}
+
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, metric := range mf.GetMetric() {
+				value := metric.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					value = metric.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:   toAttributes(metric.GetLabel()),
+					TimeUnixNano: nowNano,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+			m.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+
+		case dto.MetricType_HISTOGRAM:
+			hist := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, metric := range mf.GetMetric() {
+				h := metric.GetHistogram()
+				dp := &metricspb.HistogramDataPoint{
+					Attributes:        toAttributes(metric.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             h.GetSampleCount(),
+					Sum:               h.GetSampleSum(),
+				}
+
+				// Prometheus buckets are cumulative while OTLP buckets are not,
+				// and OTLP has an implicit +Inf bucket.
+				var prev uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+					dp.ExplicitBounds = append(dp.ExplicitBounds, b.GetUpperBound())
+					dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-prev)
+					prev = b.GetCumulativeCount()
+				}
+				dp.BucketCounts = append(dp.BucketCounts, h.GetSampleCount()-prev)
+
+				hist.DataPoints = append(hist.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Histogram{Histogram: hist}
+
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, metric := range mf.GetMetric() {
+				s := metric.GetSummary()
+				dp := &metricspb.SummaryDataPoint{
+					Attributes:        toAttributes(metric.GetLabel()),
+					StartTimeUnixNano: startNano,
+					TimeUnixNano:      nowNano,
+					Count:             s.GetSampleCount(),
+					Sum:               s.GetSampleSum(),
+				}
+				for _, q := range s.GetQuantile() {
+					dp.QuantileValues = append(dp.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: q.GetQuantile(),
+						Value:    q.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, dp)
+			}
+			m.Data = &metricspb.Metric_Summary{Summary: summary}
+
+		default:
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+func toAttributes(labels []*dto.LabelPair) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for _, l := range labels {
+		attrs = append(attrs, &commonpb.KeyValue{
+			Key:   l.GetName(),
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: l.GetValue()}},
+		})
+	}
+	return attrs
+}
+
+func toResource(res *resource.Resource) *resourcepb.Resource {
+	if res == nil {
+		return &resourcepb.Resource{}
+	}
+
+	attrs := make([]*commonpb.KeyValue, 0, res.Len())
+	for _, kv := range res.Attributes() {
+		attrs = append(attrs, toKeyValue(kv))
+	}
+	return &resourcepb.Resource{Attributes: attrs}
+}
+
+func toKeyValue(kv attribute.KeyValue) *commonpb.KeyValue {
+	v := &commonpb.AnyValue{}
+	switch kv.Value.Type() {
+	case attribute.BOOL:
+		v.Value = &commonpb.AnyValue_BoolValue{BoolValue: kv.Value.AsBool()}
+	case attribute.INT64:
+		v.Value = &commonpb.AnyValue_IntValue{IntValue: kv.Value.AsInt64()}
+	case attribute.FLOAT64:
+		v.Value = &commonpb.AnyValue_DoubleValue{DoubleValue: kv.Value.AsFloat64()}
+	default:
+		v.Value = &commonpb.AnyValue_StringValue{StringValue: kv.Value.Emit()}
+	}
+	return &commonpb.KeyValue{Key: string(kv.Key), Value: v}
+}
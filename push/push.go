@@ -0,0 +1,96 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package push periodically pushes the metrics of a Prometheus Gatherer to
+// remote backends, so that short-lived processes still land in a metrics
+// backend even if they exit before being scraped.
+package push
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// flushTimeout bounds the final push made when the Pusher stops.
+const flushTimeout = 5 * time.Second
+
+// Exporter sends gathered metric families to a remote backend.
+type Exporter interface {
+	Export(ctx context.Context, mfs []*dto.MetricFamily) error
+	Shutdown(ctx context.Context) error
+}
+
+// Pusher gathers metrics on an interval and hands them to its exporters.
+type Pusher struct {
+	g         prometheus.Gatherer
+	interval  time.Duration
+	logger    log.Logger
+	exporters []Exporter
+}
+
+// New creates a Pusher that gathers from g every interval.
+func New(g prometheus.Gatherer, interval time.Duration, logger log.Logger, exporters ...Exporter) *Pusher {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Pusher{
+		g:         g,
+		interval:  interval,
+		logger:    logger,
+		exporters: exporters,
+	}
+}
+
+// Run pushes metrics every interval until ctx is cancelled. A last push is
+// made when ctx is done so that the final values are not lost, after which
+// every exporter is shut down.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			if err := p.Push(flushCtx); err != nil {
+				_ = p.logger.Log("msg", "failed to push metrics", "err", err)
+			}
+			for _, e := range p.exporters {
+				if err := e.Shutdown(flushCtx); err != nil {
+					_ = p.logger.Log("msg", "failed to shut down exporter", "err", err)
+				}
+			}
+			return
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil {
+				_ = p.logger.Log("msg", "failed to push metrics", "err", err)
+			}
+		}
+	}
+}
+
+// Push gathers metrics once and exports them to every exporter. Every
+// exporter is attempted even if a previous one failed.
+func (p *Pusher) Push(ctx context.Context) error {
+	mfs, err := p.g.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %w", err)
+	}
+
+	var errs []string
+	for _, e := range p.exporters {
+		if err := e.Export(ctx, mfs); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("exporting metrics: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package push
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests_total",
+		Help: "test counter",
+	}, []string{"target"})
+	reg.MustRegister(counter)
+	counter.WithLabelValues("foo").Add(3)
+
+	var got [][]label
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("expected snappy encoding, got %q", r.Header.Get("Content-Encoding"))
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("failed to decode body: %s", err)
+			return
+		}
+		got = decodeLabels(t, body)
+	}))
+	defer srv.Close()
+
+	exp := NewRemoteWriteExporter(RemoteWriteConfig{
+		URL:    srv.URL,
+		Labels: map[string]string{"job": "zombie", "target": "ignored"},
+	})
+
+	p := New(reg, time.Hour, nil, exp)
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("failed to push: %s", err)
+	}
+
+	want := [][]label{{
+		{"__name__", "requests_total"},
+		{"job", "zombie"},
+		{"target", "foo"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected series %v, got %v", want, got)
+	}
+}
+
+func TestRunFlushesOnStop(t *testing.T) {
+	pushes := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes <- struct{}{}
+	}))
+	defer srv.Close()
+
+	p := New(prometheus.NewRegistry(), time.Hour, nil, NewRemoteWriteExporter(RemoteWriteConfig{URL: srv.URL}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Run(ctx)
+
+	if len(pushes) != 1 {
+		t.Errorf("expected a single push when stopping, got %d", len(pushes))
+	}
+}
+
+func TestHistogramToOTLP(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hist := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "latency_seconds",
+		Help:    "test histogram",
+		Buckets: []float64{.1, 1},
+	})
+	reg.MustRegister(hist)
+	for _, v := range []float64{.05, .5, .5, 5} {
+		hist.Observe(v)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather: %s", err)
+	}
+
+	metrics := toOTLPMetrics(mfs, time.Now(), time.Now())
+	if len(metrics) != 1 {
+		t.Fatalf("expected one metric, got %d", len(metrics))
+	}
+
+	dp := metrics[0].GetData().(*metricspb.Metric_Histogram).Histogram.GetDataPoints()[0]
+	if !reflect.DeepEqual(dp.ExplicitBounds, []float64{.1, 1}) {
+		t.Errorf("expected bounds [.1 1], got %v", dp.ExplicitBounds)
+	}
+	if !reflect.DeepEqual(dp.BucketCounts, []uint64{1, 2, 1}) {
+		t.Errorf("expected bucket counts [1 2 1], got %v", dp.BucketCounts)
+	}
+	if dp.Count != 4 {
+		t.Errorf("expected count 4, got %d", dp.Count)
+	}
+}
+
+// decodeLabels returns the label sets of every series in a WriteRequest.
+func decodeLabels(t *testing.T, b []byte) [][]label {
+	t.Helper()
+
+	var out [][]label
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		b = b[n+m:]
+
+		var labels []label
+		for len(ts) > 0 {
+			num, typ, n := protowire.ConsumeTag(ts)
+			ts = ts[n:]
+			if num != 1 {
+				n = protowire.ConsumeFieldValue(num, typ, ts)
+				ts = ts[n:]
+				continue
+			}
+
+			lb, m := protowire.ConsumeBytes(ts)
+			ts = ts[m:]
+
+			var l label
+			_, _, n = protowire.ConsumeTag(lb)
+			name, m := protowire.ConsumeString(lb[n:])
+			lb = lb[n+m:]
+			_, _, n = protowire.ConsumeTag(lb)
+			value, _ := protowire.ConsumeString(lb[n:])
+			l.name, l.value = name, value
+			labels = append(labels, l)
+		}
+		out = append(out, labels)
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteConfig configures a RemoteWriteExporter.
+type RemoteWriteConfig struct {
+	// URL of the remote write endpoint, e.g.
+	// `http://prometheus:9090/api/v1/write`.
+	URL string
+
+	// Headers added to every request.
+	Headers map[string]string
+
+	// Labels added to every series, such as `job` or `instance`. Labels
+	// already set on a series take precedence.
+	Labels map[string]string
+
+	// Client used to send requests. Defaults to a client with a 10s timeout.
+	Client *http.Client
+}
+
+// RemoteWriteExporter pushes metrics to a Prometheus remote write endpoint.
+type RemoteWriteExporter struct {
+	cfg RemoteWriteConfig
+}
+
+var _ Exporter = &RemoteWriteExporter{}
+
+// NewRemoteWriteExporter creates a RemoteWriteExporter.
+func NewRemoteWriteExporter(cfg RemoteWriteConfig) *RemoteWriteExporter {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteWriteExporter{cfg: cfg}
+}
+
+func (e *RemoteWriteExporter) Export(ctx context.Context, mfs []*dto.MetricFamily) error {
+	body := snappy.Encode(nil, encodeWriteRequest(toSeries(mfs, e.cfg.Labels, time.Now())))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("remote write: %w", err)
+	}
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	res, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("remote write: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("remote write: server returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
+
+func (e *RemoteWriteExporter) Shutdown(ctx context.Context) error { return nil }
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// toSeries flattens metric families into remote write series, following the
+// same naming as the Prometheus text exposition format.
+func toSeries(mfs []*dto.MetricFamily, extra map[string]string, now time.Time) []series {
+	var out []series
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, more ...label) {
+				out = append(out, series{
+					labels:    makeLabels(name, m.GetLabel(), extra, more...),
+					value:     value,
+					timestamp: ts,
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						hasInf = true
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+
+	return out
+}
+
+// makeLabels builds the sorted label set of a series.
+func makeLabels(name string, pairs []*dto.LabelPair, extra map[string]string, more ...label) []label {
+	set := make(map[string]string, len(pairs)+len(extra)+len(more)+1)
+	for k, v := range extra {
+		set[k] = v
+	}
+	for _, p := range pairs {
+		set[p.GetName()] = p.GetValue()
+	}
+	for _, l := range more {
+		set[l.name] = l.value
+	}
+	set["__name__"] = name
+
+	labels := make([]label, 0, len(set))
+	for k, v := range set {
+		labels = append(labels, label{k, v})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf
+// message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(ss []series) []byte {
+	var req []byte
+	for _, s := range ss {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
@@ -0,0 +1,413 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package receiver implements a minimal OTLP trace receiver for local
+// debugging. Received spans are stored in memory, optionally printed by a
+// span exporter such as the debugprocessor's, and served by a small trace
+// listing and search API.
+package receiver
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wperron/o11yutil/debugprocessor"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxBodySize limits the size of OTLP/HTTP requests.
+const maxBodySize = 32 << 20
+
+// Option configures a Receiver.
+type Option func(*Receiver)
+
+// WithExporter sets an exporter every received span is passed to, e.g. a
+// debugprocessor.Exporter printing them.
+func WithExporter(exp sdktrace.SpanExporter) Option {
+	return func(r *Receiver) {
+		r.exporter = exp
+	}
+}
+
+// WithMaxTraces sets the number of traces kept in memory. Defaults to 1000.
+func WithMaxTraces(n int) Option {
+	return func(r *Receiver) {
+		r.store = NewStore(n)
+	}
+}
+
+// Receiver receives spans over OTLP gRPC and HTTP.
+type Receiver struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	store    *Store
+	exporter sdktrace.SpanExporter
+	mux      *http.ServeMux
+
+	grpcSrv *grpc.Server
+	httpSrv *http.Server
+}
+
+// New creates a Receiver.
+func New(opts ...Option) *Receiver {
+	r := &Receiver{
+		store: NewStore(defaultMaxTraces),
+		mux:   http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.grpcSrv = grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(r.grpcSrv, r)
+	r.httpSrv = &http.Server{Handler: r}
+
+	r.mux.HandleFunc("/v1/traces", r.handleOTLP)
+	r.mux.HandleFunc("/api/traces", r.handleSearch)
+	r.mux.HandleFunc("/api/traces/", r.handleTrace)
+	return r
+}
+
+// Store returns the traces received so far.
+func (r *Receiver) Store() *Store {
+	return r.store
+}
+
+// Export implements the OTLP gRPC trace service.
+func (r *Receiver) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	r.receive(ctx, req)
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+// receive stores the spans of req, and passes them to the exporter.
+func (r *Receiver) receive(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) {
+	spans := Spans(req.ResourceSpans)
+	if len(spans) == 0 {
+		return
+	}
+
+	r.store.Add(spans)
+	if r.exporter != nil {
+		if err := r.exporter.ExportSpans(ctx, spans); err != nil {
+			otel.Handle(fmt.Errorf("exporting received spans: %w", err))
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// ListenAndServe serves OTLP gRPC on grpcAddr, and OTLP/HTTP and the API on
+// httpAddr, until Shutdown is called. It then returns http.ErrServerClosed,
+// even if Shutdown was called before it.
+func (r *Receiver) ListenAndServe(grpcAddr, httpAddr string) error {
+	grpcLis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	httpLis, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		grpcLis.Close()
+		return err
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		err := r.grpcSrv.Serve(grpcLis)
+		if err == nil || errors.Is(err, grpc.ErrServerStopped) {
+			err = http.ErrServerClosed
+		}
+		errs <- err
+	}()
+	go func() {
+		errs <- r.httpSrv.Serve(httpLis)
+	}()
+	return <-errs
+}
+
+// Shutdown gracefully stops the receiver, then shuts the exporter down.
+func (r *Receiver) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		r.grpcSrv.GracefulStop()
+		close(stopped)
+	}()
+
+	err := r.httpSrv.Shutdown(ctx)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		r.grpcSrv.Stop()
+	}
+
+	if r.exporter != nil {
+		if expErr := r.exporter.Shutdown(ctx); err == nil {
+			err = expErr
+		}
+	}
+	return err
+}
+
+// handleOTLP serves `POST /v1/traces`, in protobuf or JSON depending on the
+// request's Content-Type.
+func (r *Receiver) handleOTLP(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	var body io.Reader = http.MaxBytesReader(w, req.Body, maxBodySize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %w", err))
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading body: %w", err))
+		return
+	}
+
+	var (
+		unmarshal func([]byte, proto.Message) error
+		marshal   func(proto.Message) ([]byte, error)
+	)
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/x-protobuf"):
+		unmarshal, marshal = proto.Unmarshal, proto.Marshal
+	case strings.HasPrefix(contentType, "application/json"):
+		unmarshal, marshal = protojson.Unmarshal, protojson.Marshal
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", contentType))
+		return
+	}
+
+	export := &coltracepb.ExportTraceServiceRequest{}
+	if err := unmarshal(b, export); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding spans: %w", err))
+		return
+	}
+	r.receive(req.Context(), export)
+
+	resp, err := marshal(&coltracepb.ExportTraceServiceResponse{})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp)
+}
+
+// handleSearch serves `GET /api/traces`, filtered by the service, name,
+// minDuration, maxDuration, errors and limit query parameters.
+func (r *Receiver) handleSearch(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	params := req.URL.Query()
+	q := Query{
+		Service:    params.Get("service"),
+		Name:       params.Get("name"),
+		ErrorsOnly: params.Get("errors") == "true",
+	}
+	var err error
+	if q.MinDuration, err = parseDuration(params.Get("minDuration")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid minDuration: %w", err))
+		return
+	}
+	if q.MaxDuration, err = parseDuration(params.Get("maxDuration")); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid maxDuration: %w", err))
+		return
+	}
+	if s := params.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+	}
+
+	traces := r.store.Search(q)
+	if traces == nil {
+		traces = []Summary{}
+	}
+	writeJSON(w, http.StatusOK, traces)
+}
+
+type spanResponse struct {
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	Name          string                 `json:"name"`
+	Service       string                 `json:"service"`
+	Library       string                 `json:"library"`
+	Kind          string                 `json:"kind"`
+	Status        string                 `json:"status"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+	Start         time.Time              `json:"start"`
+	DurationMs    float64                `json:"duration_ms"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	Events        []eventResponse        `json:"events,omitempty"`
+}
+
+type eventResponse struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// handleTrace serves `GET /api/traces/{traceID}` as JSON, or as a tree of
+// spans printed by the debugprocessor with `?format=text`.
+func (r *Receiver) handleTrace(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	id, err := trace.TraceIDFromHex(strings.TrimPrefix(req.URL.Path, "/api/traces/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid trace ID"))
+		return
+	}
+	spans, ok := r.store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("trace %s not found", id))
+		return
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].StartTime().Before(spans[j].StartTime())
+	})
+
+	if req.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeText(req.Context(), w, spans)
+		return
+	}
+
+	resp := make([]spanResponse, 0, len(spans))
+	for _, s := range spans {
+		resp = append(resp, toSpanResponse(s))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"trace_id": id.String(),
+		"spans":    resp,
+	})
+}
+
+// writeText prints spans as trees with the debugprocessor.
+func writeText(ctx context.Context, w io.Writer, spans []sdktrace.ReadOnlySpan) {
+	exp := debugprocessor.New(
+		debugprocessor.WithWriter(w),
+		debugprocessor.WithQueueSize(0),
+		debugprocessor.WithColors(debugprocessor.ColorNever),
+		debugprocessor.WithIDs(true),
+		debugprocessor.WithResource(true),
+	).BuildExporter()
+
+	// Hold the roots back until every other span is buffered.
+	var roots []sdktrace.ReadOnlySpan
+	var rest []sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		if !s.Parent().IsValid() {
+			roots = append(roots, s)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	_ = exp.ExportSpans(ctx, append(rest, roots...))
+	_ = exp.Shutdown(ctx)
+}
+
+func toSpanResponse(s sdktrace.ReadOnlySpan) spanResponse {
+	resp := spanResponse{
+		SpanID:        s.SpanContext().SpanID().String(),
+		Name:          s.Name(),
+		Service:       serviceName(s),
+		Library:       s.InstrumentationLibrary().Name,
+		Kind:          s.SpanKind().String(),
+		Status:        s.Status().Code.String(),
+		StatusMessage: s.Status().Description,
+		Start:         s.StartTime(),
+		DurationMs:    float64(s.EndTime().Sub(s.StartTime())) / float64(time.Millisecond),
+		Attributes:    attributeMap(s.Attributes()),
+	}
+	if s.Parent().IsValid() {
+		resp.ParentSpanID = s.Parent().SpanID().String()
+	}
+	for _, e := range s.Events() {
+		resp.Events = append(resp.Events, eventResponse{
+			Name:       e.Name,
+			Time:       e.Time,
+			Attributes: attributeMap(e.Attributes),
+		})
+	}
+	return resp
+}
+
+func attributeMap(attrs []attribute.KeyValue) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]interface{}, len(attrs))
+	for _, kv := range attrs {
+		m[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return m
+}
+
+// parseDuration parses a duration such as 100ms, or a number of
+// milliseconds.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,253 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wperron/o11yutil/debugprocessor"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestReceiver(t *testing.T) {
+	var printed bytes.Buffer
+	r := New(WithExporter(debugprocessor.New(
+		debugprocessor.WithWriter(&printed),
+		debugprocessor.WithQueueSize(0),
+	).BuildExporter()))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go r.grpcSrv.Serve(lis) // nolint
+	defer r.grpcSrv.Stop()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx := context.Background()
+	grpcExp, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(lis.Addr().String()), otlptracegrpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	httpExp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(strings.TrimPrefix(srv.URL, "http://")), otlptracehttp.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// The frontend sends its spans over gRPC, the backend over HTTP.
+	frontend := sdktrace.NewTracerProvider(sdktrace.WithSyncer(grpcExp), sdktrace.WithResource(service("frontend")))
+	backend := sdktrace.NewTracerProvider(sdktrace.WithSyncer(httpExp), sdktrace.WithResource(service("backend")))
+
+	fctx, root := frontend.Tracer("test").Start(ctx, "GET /", trace.WithSpanKind(trace.SpanKindServer))
+	cctx, client := frontend.Tracer("test").Start(fctx, "call backend", trace.WithSpanKind(trace.SpanKindClient))
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(cctx, carrier)
+	bctx := propagation.TraceContext{}.Extract(ctx, carrier)
+	_, server := backend.Tracer("test").Start(bctx, "GET /backend",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.StringSlice("tags", []string{"a", "b"})),
+	)
+	server.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", 2)))
+	server.SetStatus(codes.Error, "failed")
+	server.End()
+	client.End()
+	root.End()
+
+	for _, tp := range []*sdktrace.TracerProvider{frontend, backend} {
+		if err := tp.Shutdown(ctx); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// Spans of both services are printed as a single tree.
+	lines := strings.Split(strings.TrimSuffix(printed.String(), "\n"), "\n")
+	prefixes := []string{
+		"test::GET /{} ",
+		"└─ test::call backend{} +",
+		"   └─ test::GET /backend{tags=[a b]} +",
+		"         · retry{attempt=2} +",
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(prefixes), len(lines), printed.String())
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected line %d to start with %q, got %q", i, prefix, lines[i])
+		}
+	}
+
+	var traces []Summary
+	get(t, srv.URL+"/api/traces?service=backend&errors=true", http.StatusOK, &traces)
+	traceID := root.SpanContext().TraceID().String()
+	if len(traces) != 1 || traces[0].TraceID != traceID {
+		t.Fatalf("expected the trace to be found, got %+v", traces)
+	}
+	got := traces[0]
+	if got.RootService != "frontend" || got.RootName != "GET /" || got.Spans != 3 || got.Errors != 1 {
+		t.Errorf("unexpected summary %+v", got)
+	}
+	if strings.Join(got.Services, ",") != "backend,frontend" {
+		t.Errorf("expected both services, got %v", got.Services)
+	}
+
+	get(t, srv.URL+"/api/traces?name=POST*", http.StatusOK, &traces)
+	if len(traces) != 0 {
+		t.Errorf("expected no trace with a POST span, got %+v", traces)
+	}
+
+	var detail struct {
+		TraceID string         `json:"trace_id"`
+		Spans   []spanResponse `json:"spans"`
+	}
+	get(t, srv.URL+"/api/traces/"+traceID, http.StatusOK, &detail)
+	if len(detail.Spans) != 3 || detail.Spans[2].Service != "backend" || detail.Spans[2].Status != "Error" {
+		t.Errorf("unexpected spans %+v", detail.Spans)
+	}
+	if detail.Spans[2].Attributes["tags"] == nil || detail.Spans[2].Events[0].Name != "retry" {
+		t.Errorf("expected attributes and events, got %+v", detail.Spans[2])
+	}
+
+	res, err := http.Get(srv.URL + "/api/traces/" + traceID + "?format=text")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	text, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.HasPrefix(string(text), "test::GET /{} ") || strings.Count(string(text), "\n") != len(prefixes) {
+		t.Errorf("expected the trace as a tree, got %q", text)
+	}
+
+	get(t, srv.URL+"/api/traces/"+strings.Repeat("0", 31)+"1", http.StatusNotFound, nil)
+	get(t, srv.URL+"/api/traces/nope", http.StatusBadRequest, nil)
+	get(t, srv.URL+"/api/traces?minDuration=nope", http.StatusBadRequest, nil)
+}
+
+func TestReceiverJSON(t *testing.T) {
+	r := New()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	body := `{"resourceSpans":[{"instrumentationLibrarySpans":[{"spans":[{
+		"traceId":"AAAAAAAAAAAAAAAAAAAAAQ==","spanId":"AAAAAAAAAAE=","name":"root",
+		"startTimeUnixNano":"1635768000000000000","endTimeUnixNano":"1635768001000000000"}]}]}]}`
+	res, err := http.Post(srv.URL+"/v1/traces", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", res.Status)
+	}
+
+	traces := r.Store().Search(Query{MinDuration: time.Second})
+	if len(traces) != 1 || traces[0].RootName != "root" || traces[0].RootService != "unknown" {
+		t.Errorf("unexpected traces %+v", traces)
+	}
+
+	res, err = http.Post(srv.URL+"/v1/traces", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415, got %s", res.Status)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Shutting down before serving, as when a signal arrives early, stops
+	// ListenAndServe right away.
+	r := New()
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := r.ListenAndServe("127.0.0.1:0", "127.0.0.1:0"); err != http.ErrServerClosed {
+		t.Errorf("expected %v, got %v", http.ErrServerClosed, err)
+	}
+
+	r = New()
+	served := make(chan error, 1)
+	go func() {
+		served <- r.ListenAndServe("127.0.0.1:0", "127.0.0.1:0")
+	}()
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case err := <-served:
+		if err != http.ErrServerClosed {
+			t.Errorf("expected %v, got %v", http.ErrServerClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected ListenAndServe to return after Shutdown")
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(2)
+	tp := sdktrace.NewTracerProvider()
+	var ids []trace.TraceID
+	for i := 0; i < 3; i++ {
+		_, span := tp.Tracer("test").Start(context.Background(), "root")
+		span.End()
+		s.Add([]sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)})
+		ids = append(ids, span.SpanContext().TraceID())
+	}
+
+	if _, ok := s.Get(ids[0]); ok {
+		t.Errorf("expected the oldest trace to be evicted")
+	}
+	if _, ok := s.Get(ids[2]); !ok {
+		t.Errorf("expected the newest trace to be kept")
+	}
+	if got := s.Search(Query{Limit: 1}); len(got) != 1 || got[0].TraceID != ids[2].String() {
+		t.Errorf("expected the most recent trace first, got %+v", got)
+	}
+
+	_, span := tp.Tracer("test").Start(context.Background(), "GET /users/42")
+	span.End()
+	s.Add([]sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)})
+	if got := s.Search(Query{Name: "GET /users/*"}); len(got) != 1 || got[0].RootName != "GET /users/42" {
+		t.Errorf("expected the GET trace to match, got %+v", got)
+	}
+	if got := s.Search(Query{Name: "POST*"}); len(got) != 0 {
+		t.Errorf("expected no trace to match, got %+v", got)
+	}
+}
+
+func service(name string) *resource.Resource {
+	return resource.NewSchemaless(semconv.ServiceNameKey.String(name))
+}
+
+func get(t *testing.T, url string, code int, v interface{}) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != code {
+		t.Fatalf("expected %d from %s, got %s", code, url, res.Status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
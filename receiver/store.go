@@ -0,0 +1,211 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+package receiver
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wperron/o11yutil/internal/glob"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultMaxTraces   = 1000
+	defaultSearchLimit = 20
+)
+
+// Store keeps the spans of the most recently updated traces in memory.
+type Store struct {
+	max int
+
+	mu sync.Mutex
+	// traces holds the elements of order, from the least to the most
+	// recently updated trace.
+	traces map[trace.TraceID]*list.Element
+	order  *list.List
+}
+
+type storedTrace struct {
+	id    trace.TraceID
+	spans []sdktrace.ReadOnlySpan
+}
+
+// NewStore creates a Store of at most max traces, evicting the least
+// recently updated ones past that.
+func NewStore(max int) *Store {
+	if max <= 0 {
+		max = defaultMaxTraces
+	}
+	return &Store{
+		max:    max,
+		traces: make(map[trace.TraceID]*list.Element),
+		order:  list.New(),
+	}
+}
+
+// Add stores spans with the other spans of their trace.
+func (s *Store) Add(spans []sdktrace.ReadOnlySpan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, span := range spans {
+		id := span.SpanContext().TraceID()
+		e, ok := s.traces[id]
+		if !ok {
+			e = s.order.PushBack(&storedTrace{id: id})
+			s.traces[id] = e
+		}
+		s.order.MoveToBack(e)
+		t := e.Value.(*storedTrace)
+		t.spans = append(t.spans, span)
+	}
+
+	for s.order.Len() > s.max {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.traces, oldest.Value.(*storedTrace).id)
+	}
+}
+
+// Get returns the spans of the trace with the given ID.
+func (s *Store) Get(id trace.TraceID) ([]sdktrace.ReadOnlySpan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.traces[id]
+	if !ok {
+		return nil, false
+	}
+	spans := e.Value.(*storedTrace).spans
+	return append([]sdktrace.ReadOnlySpan(nil), spans...), true
+}
+
+// Query selects traces in a Search. Zero fields match every trace.
+type Query struct {
+	// Service is the name of a service taking part in the trace.
+	Service string
+	// Name is a glob.Match pattern of the name of a span in the trace.
+	Name string
+	// MinDuration and MaxDuration bound the duration of the trace.
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// ErrorsOnly only matches traces with a failed span.
+	ErrorsOnly bool
+	// Limit is the maximum number of traces returned, 20 by default.
+	Limit int
+}
+
+// Summary describes a stored trace.
+type Summary struct {
+	TraceID     string    `json:"trace_id"`
+	RootService string    `json:"root_service"`
+	RootName    string    `json:"root_name"`
+	Services    []string  `json:"services"`
+	Start       time.Time `json:"start"`
+	DurationMs  float64   `json:"duration_ms"`
+	Spans       int       `json:"spans"`
+	Errors      int       `json:"errors"`
+}
+
+// Search returns the summaries of the traces matching q, the most recent
+// first.
+func (s *Store) Search(q Query) []Summary {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	s.mu.Lock()
+	var matches []Summary
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*storedTrace)
+		if sum, ok := summarize(t, q); ok {
+			matches = append(matches, sum)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Start.After(matches[j].Start)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// summarize returns the summary of t, and whether it matches q.
+func summarize(t *storedTrace, q Query) (Summary, bool) {
+	sum := Summary{TraceID: t.id.String(), Spans: len(t.spans)}
+
+	ids := make(map[trace.SpanID]bool, len(t.spans))
+	for _, span := range t.spans {
+		ids[span.SpanContext().SpanID()] = true
+	}
+
+	var (
+		root         sdktrace.ReadOnlySpan
+		start, end   time.Time
+		services     = map[string]bool{}
+		serviceFound = q.Service == ""
+		nameFound    = q.Name == ""
+	)
+	for _, span := range t.spans {
+		if start.IsZero() || span.StartTime().Before(start) {
+			start = span.StartTime()
+		}
+		if span.EndTime().After(end) {
+			end = span.EndTime()
+		}
+		if span.Status().Code == codes.Error {
+			sum.Errors++
+		}
+
+		service := serviceName(span)
+		if !services[service] {
+			services[service] = true
+			sum.Services = append(sum.Services, service)
+		}
+		if service == q.Service {
+			serviceFound = true
+		}
+		if !nameFound {
+			nameFound = glob.Match(q.Name, span.Name())
+		}
+
+		// The root is the earliest span without a parent in the trace.
+		if !ids[span.Parent().SpanID()] && (root == nil || span.StartTime().Before(root.StartTime())) {
+			root = span
+		}
+	}
+	sort.Strings(sum.Services)
+
+	if root != nil {
+		sum.RootService = serviceName(root)
+		sum.RootName = root.Name()
+	}
+	sum.Start = start
+	duration := end.Sub(start)
+	sum.DurationMs = float64(duration) / float64(time.Millisecond)
+
+	match := serviceFound && nameFound &&
+		duration >= q.MinDuration &&
+		(q.MaxDuration == 0 || duration <= q.MaxDuration) &&
+		(!q.ErrorsOnly || sum.Errors > 0)
+	return sum, match
+}
+
+func serviceName(span sdktrace.ReadOnlySpan) string {
+	for _, kv := range span.Resource().Attributes() {
+		if kv.Key == semconv.ServiceNameKey {
+			return kv.Value.AsString()
+		}
+	}
+	return "unknown"
+}
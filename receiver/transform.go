@@ -0,0 +1,209 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+package receiver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Spans converts received OTLP spans to read-only spans, as if they had been
+// recorded in this process. Parents are local rather than remote, so that the
+// debugprocessor prints the spans of several services in a single tree when
+// it gets them together.
+func Spans(resourceSpans []*tracepb.ResourceSpans) []sdktrace.ReadOnlySpan {
+	var stubs tracetest.SpanStubs
+	for _, rs := range resourceSpans {
+		res := resource.NewWithAttributes(rs.SchemaUrl, keyValues(rs.GetResource().GetAttributes())...)
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			lib := instrumentation.Library{
+				Name:      ils.GetInstrumentationLibrary().GetName(),
+				Version:   ils.GetInstrumentationLibrary().GetVersion(),
+				SchemaURL: ils.SchemaUrl,
+			}
+			for _, s := range ils.Spans {
+				stub := span(s)
+				stub.Resource = res
+				stub.InstrumentationLibrary = lib
+				stubs = append(stubs, stub)
+			}
+		}
+	}
+	return stubs.Snapshots()
+}
+
+func span(s *tracepb.Span) tracetest.SpanStub {
+	traceID := toTraceID(s.TraceId)
+	stub := tracetest.SpanStub{
+		Name:              s.Name,
+		SpanContext:       spanContext(traceID, s.SpanId, s.TraceState),
+		SpanKind:          spanKind(s.Kind),
+		StartTime:         unixNano(s.StartTimeUnixNano),
+		EndTime:           unixNano(s.EndTimeUnixNano),
+		Attributes:        keyValues(s.Attributes),
+		Status:            status(s.Status),
+		DroppedAttributes: int(s.DroppedAttributesCount),
+		DroppedEvents:     int(s.DroppedEventsCount),
+		DroppedLinks:      int(s.DroppedLinksCount),
+	}
+	if len(s.ParentSpanId) > 0 {
+		stub.Parent = spanContext(traceID, s.ParentSpanId, "")
+	}
+
+	for _, e := range s.Events {
+		stub.Events = append(stub.Events, sdktrace.Event{
+			Name:                  e.Name,
+			Attributes:            keyValues(e.Attributes),
+			DroppedAttributeCount: int(e.DroppedAttributesCount),
+			Time:                  unixNano(e.TimeUnixNano),
+		})
+	}
+	for _, l := range s.Links {
+		stub.Links = append(stub.Links, sdktrace.Link{
+			SpanContext:           spanContext(toTraceID(l.TraceId), l.SpanId, l.TraceState),
+			Attributes:            keyValues(l.Attributes),
+			DroppedAttributeCount: int(l.DroppedAttributesCount),
+		})
+	}
+	return stub
+}
+
+func toTraceID(b []byte) trace.TraceID {
+	var id trace.TraceID
+	copy(id[:], b)
+	return id
+}
+
+func spanContext(traceID trace.TraceID, spanID []byte, traceState string) trace.SpanContext {
+	conf := trace.SpanContextConfig{TraceID: traceID, TraceFlags: trace.FlagsSampled}
+	copy(conf.SpanID[:], spanID)
+	if ts, err := trace.ParseTraceState(traceState); err == nil {
+		conf.TraceState = ts
+	}
+	return trace.NewSpanContext(conf)
+}
+
+func unixNano(t uint64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(t))
+}
+
+func spanKind(k tracepb.Span_SpanKind) trace.SpanKind {
+	switch k {
+	case tracepb.Span_SPAN_KIND_INTERNAL:
+		return trace.SpanKindInternal
+	case tracepb.Span_SPAN_KIND_SERVER:
+		return trace.SpanKindServer
+	case tracepb.Span_SPAN_KIND_CLIENT:
+		return trace.SpanKindClient
+	case tracepb.Span_SPAN_KIND_PRODUCER:
+		return trace.SpanKindProducer
+	case tracepb.Span_SPAN_KIND_CONSUMER:
+		return trace.SpanKindConsumer
+	default:
+		return trace.SpanKindUnspecified
+	}
+}
+
+func status(s *tracepb.Status) sdktrace.Status {
+	out := sdktrace.Status{Description: s.GetMessage()}
+	switch s.GetCode() {
+	case tracepb.Status_STATUS_CODE_OK:
+		out.Code = codes.Ok
+	case tracepb.Status_STATUS_CODE_ERROR:
+		out.Code = codes.Error
+	}
+	return out
+}
+
+func keyValues(kvs []*commonpb.KeyValue) []attribute.KeyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	out := make([]attribute.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		out = append(out, attribute.KeyValue{Key: attribute.Key(kv.Key), Value: value(kv.Value)})
+	}
+	return out
+}
+
+// value converts v to an attribute value. Arrays of a single type become
+// slices, and the values attributes can't hold, like maps, become strings.
+func value(v *commonpb.AnyValue) attribute.Value {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return attribute.StringValue(v.StringValue)
+	case *commonpb.AnyValue_BoolValue:
+		return attribute.BoolValue(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return attribute.Int64Value(v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return attribute.Float64Value(v.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return attribute.StringValue(base64.StdEncoding.EncodeToString(v.BytesValue))
+	case *commonpb.AnyValue_ArrayValue:
+		return arrayValue(v.ArrayValue.GetValues())
+	case *commonpb.AnyValue_KvlistValue:
+		var kvs []string
+		for _, kv := range keyValues(v.KvlistValue.GetValues()) {
+			kvs = append(kvs, fmt.Sprintf("%s=%s", kv.Key, kv.Value.Emit()))
+		}
+		return attribute.StringValue("{" + strings.Join(kvs, ", ") + "}")
+	default:
+		return attribute.StringValue("")
+	}
+}
+
+func arrayValue(values []*commonpb.AnyValue) attribute.Value {
+	var (
+		strs   []string
+		bools  []bool
+		ints   []int64
+		floats []float64
+	)
+	for _, v := range values {
+		switch v := v.GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			strs = append(strs, v.StringValue)
+		case *commonpb.AnyValue_BoolValue:
+			bools = append(bools, v.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			ints = append(ints, v.IntValue)
+		case *commonpb.AnyValue_DoubleValue:
+			floats = append(floats, v.DoubleValue)
+		}
+	}
+
+	switch len(values) {
+	case len(strs):
+		return attribute.StringSliceValue(strs)
+	case len(bools):
+		return attribute.BoolSliceValue(bools)
+	case len(ints):
+		return attribute.Int64SliceValue(ints)
+	case len(floats):
+		return attribute.Float64SliceValue(floats)
+	}
+
+	// Mixed types
+	emitted := make([]string, 0, len(values))
+	for _, v := range values {
+		emitted = append(emitted, value(v).Emit())
+	}
+	return attribute.StringSliceValue(emitted)
+}
@@ -0,0 +1,503 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package runner drives the zombie load generator from Go code. A Runner
+// spawns the workers described by a config.Config and can be started,
+// stopped, scaled and queried for live results, which makes it possible to
+// generate synthetic load from within `go test`.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/client"
+	"github.com/wperron/o11yutil/config"
+	"github.com/wperron/o11yutil/internal/promutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// recentResults is the number of results and failures kept for each
+	// target.
+	recentResults = 20
+
+	// window is the period over which live rates and latency percentiles are
+	// computed.
+	window = time.Minute
+
+	// maxWindowResults bounds the memory used by the window of busy targets.
+	maxWindowResults = 10000
+
+	// defaultNamespace of the runner's own metrics when the metrics options
+	// don't set one.
+	defaultNamespace = "zombie"
+)
+
+var (
+	ErrAlreadyStarted = errors.New("runner already started")
+	ErrNotStarted     = errors.New("runner not started")
+	ErrUnknownTarget  = errors.New("unknown target")
+)
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithTracerProvider sets the TracerProvider used to trace requests. Defaults
+// to the global TracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(r *Runner) {
+		r.tp = tp
+	}
+}
+
+// WithRegisterer sets the Prometheus Registerer the runner's metrics are
+// registered with. Defaults to prometheus.DefaultRegisterer.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(r *Runner) {
+		r.reg = reg
+	}
+}
+
+// WithMetricsOpts configures the naming, labels and buckets of the client
+// metrics recorded by the runner's workers.
+func WithMetricsOpts(opts client.MetricsOpts) Option {
+	return func(r *Runner) {
+		r.metricsOpts = opts
+	}
+}
+
+// WithLogger sets the logger used to report worker lifecycle events. Defaults
+// to a no-op logger.
+func WithLogger(l log.Logger) Option {
+	return func(r *Runner) {
+		r.logger = l
+	}
+}
+
+// Runner generates load against a set of targets.
+type Runner struct {
+	tp          trace.TracerProvider
+	reg         prometheus.Registerer
+	metricsOpts client.MetricsOpts
+	logger      log.Logger
+	tracer      trace.Tracer
+
+	metrics      *client.Metrics
+	workersGauge *prometheus.GaugeVec
+
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
+	wg        sync.WaitGroup
+	targets   []*target
+}
+
+// target holds the live state of a single configured target.
+type target struct {
+	name    string
+	conf    config.Target
+	url     *url.URL
+	initial int
+	workers []context.CancelFunc
+
+	// paused targets keep the number of workers to restore on resume in
+	// desired.
+	paused  bool
+	desired int
+
+	mu       sync.Mutex
+	requests uint64
+	errors   uint64
+	recent   []client.Result
+	failures []client.Result
+	window   []client.Result
+}
+
+// TargetStats is a snapshot of the live state of a target.
+type TargetStats struct {
+	Name     string
+	URL      string
+	Config   config.Target
+	Workers  int
+	Paused   bool
+	Requests uint64
+	Errors   uint64
+
+	// RPS and ErrorRate are computed over the last minute.
+	RPS       float64
+	ErrorRate float64
+
+	// Latency percentiles of the requests made in the last minute.
+	Latency Percentiles
+
+	// Recent holds the most recent results, oldest first.
+	Recent []client.Result
+
+	// Failures holds the most recent failed results, oldest first.
+	Failures []client.Result
+}
+
+// Percentiles of request latencies.
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// New creates a Runner for the targets in conf. The runner doesn't send any
+// request until Start is called.
+func New(conf config.Config, opts ...Option) (*Runner, error) {
+	r := &Runner{
+		tp:     otel.GetTracerProvider(),
+		reg:    prometheus.DefaultRegisterer,
+		logger: log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.tracer = r.tp.Tracer("zombie")
+
+	namespace := r.metricsOpts.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	r.workersGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   r.metricsOpts.Subsystem,
+			Name:        "workers",
+			Help:        "The number of running workers per target.",
+			ConstLabels: r.metricsOpts.ConstLabels,
+		},
+		[]string{"target"},
+	)
+	c, err := promutil.Register(r.reg, r.workersGauge)
+	if err != nil {
+		return nil, err
+	}
+	r.workersGauge = c.(*prometheus.GaugeVec)
+
+	m, err := client.NewMetrics(r.reg, r.metricsOpts)
+	if err != nil {
+		return nil, err
+	}
+	r.metrics = m
+
+	for _, t := range conf.Targets {
+		name := t.Name
+		if name == "" {
+			name = t.Url
+		}
+
+		u, err := url.Parse(t.Url)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: invalid URL: %w", name, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("target %s: URL %q must be absolute", name, t.Url)
+		}
+
+		workers := t.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+
+		r.targets = append(r.targets, &target{
+			name:    name,
+			conf:    t,
+			url:     u,
+			initial: workers,
+		})
+	}
+
+	return r, nil
+}
+
+// Start spawns the configured number of workers for every target. Workers run
+// until Stop is called or ctx is cancelled.
+func (r *Runner) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		return ErrAlreadyStarted
+	}
+
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.startedAt = time.Now()
+	for _, t := range r.targets {
+		t.paused = false
+		r.scale(t, t.initial)
+	}
+	return nil
+}
+
+// Running reports whether the runner has been started and not yet stopped.
+func (r *Runner) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancel != nil
+}
+
+// Stop cancels every worker and waits for in-flight requests to complete.
+// A stopped Runner can be started again.
+func (r *Runner) Stop() {
+	r.mu.Lock()
+	if r.cancel == nil {
+		r.mu.Unlock()
+		return
+	}
+	r.cancel()
+	r.ctx, r.cancel = nil, nil
+	for _, t := range r.targets {
+		t.workers = nil
+		r.workersGauge.WithLabelValues(t.name).Set(0)
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// Scale changes the number of workers running for the named target. Scaling
+// to 0 leaves the target idle without removing it. Scaling a paused target
+// changes the number of workers started when it is resumed.
+func (r *Runner) Scale(name string, workers int) error {
+	if workers < 0 {
+		return fmt.Errorf("invalid number of workers %d", workers)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.started(name)
+	if err != nil {
+		return err
+	}
+
+	if t.paused {
+		t.desired = workers
+		return nil
+	}
+
+	r.scale(t, workers)
+	return nil
+}
+
+// Pause stops every worker of the named target until it is resumed. Pausing
+// a paused target does nothing.
+func (r *Runner) Pause(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.started(name)
+	if err != nil {
+		return err
+	}
+
+	if t.paused {
+		return nil
+	}
+
+	t.paused = true
+	t.desired = len(t.workers)
+	r.scale(t, 0)
+	return nil
+}
+
+// Resume restarts the workers of a paused target. Resuming a target that
+// isn't paused does nothing.
+func (r *Runner) Resume(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, err := r.started(name)
+	if err != nil {
+		return err
+	}
+
+	if !t.paused {
+		return nil
+	}
+
+	t.paused = false
+	r.scale(t, t.desired)
+	return nil
+}
+
+// Stats returns a snapshot of the live state of every target, in the order
+// they were configured.
+func (r *Runner) Stats() []TargetStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	elapsed := window
+	if since := now.Sub(r.startedAt); since < window {
+		elapsed = since
+	}
+
+	stats := make([]TargetStats, 0, len(r.targets))
+	for _, t := range r.targets {
+		t.mu.Lock()
+		t.prune(now)
+
+		s := TargetStats{
+			Name:     t.name,
+			URL:      t.conf.Url,
+			Config:   t.conf,
+			Workers:  len(t.workers),
+			Paused:   t.paused,
+			Requests: t.requests,
+			Errors:   t.errors,
+			Latency:  percentiles(t.window),
+			Recent:   append([]client.Result(nil), t.recent...),
+			Failures: append([]client.Result(nil), t.failures...),
+		}
+
+		if n := len(t.window); n > 0 {
+			failed := 0
+			for _, res := range t.window {
+				if isError(res) {
+					failed++
+				}
+			}
+			s.ErrorRate = float64(failed) / float64(n)
+			if elapsed > 0 {
+				s.RPS = float64(n) / elapsed.Seconds()
+			}
+		}
+
+		stats = append(stats, s)
+		t.mu.Unlock()
+	}
+	return stats
+}
+
+// target returns the target with the given name, or nil. r.mu must be held.
+func (r *Runner) target(name string) *target {
+	for _, t := range r.targets {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// started returns the named target if the runner is started. r.mu must be
+// held.
+func (r *Runner) started(name string) (*target, error) {
+	if r.cancel == nil {
+		return nil, ErrNotStarted
+	}
+
+	t := r.target(name)
+	if t == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownTarget, name)
+	}
+	return t, nil
+}
+
+// scale spawns or cancels workers until n are running for t. r.mu must be
+// held.
+func (r *Runner) scale(t *target, n int) {
+	for len(t.workers) < n {
+		ctx, cancel := context.WithCancel(r.ctx)
+		t.workers = append(t.workers, cancel)
+
+		pinger := client.NewInstrumentedPinger(t.name, r.tracer, r.metrics,
+			client.WithResultHandler(t.record),
+		)
+
+		id := len(t.workers)
+		_ = r.logger.Log("msg", "starting worker", "target", t.name, "worker", id)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			pinger.Ping(ctx, t.conf, t.url)
+		}()
+	}
+
+	for len(t.workers) > n {
+		last := len(t.workers) - 1
+		t.workers[last]()
+		t.workers = t.workers[:last]
+		_ = r.logger.Log("msg", "stopping worker", "target", t.name, "worker", last+1)
+	}
+
+	r.workersGauge.WithLabelValues(t.name).Set(float64(n))
+}
+
+func (t *target) record(res client.Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests++
+	if isError(res) {
+		t.errors++
+		t.failures = appendBounded(t.failures, res, recentResults)
+	}
+
+	t.recent = appendBounded(t.recent, res, recentResults)
+	t.window = appendBounded(t.window, res, maxWindowResults)
+	t.prune(time.Now())
+}
+
+// prune drops the results that fell out of the window. t.mu must be held.
+func (t *target) prune(now time.Time) {
+	i := 0
+	for i < len(t.window) && now.Sub(t.window[i].Time) > window {
+		i++
+	}
+	t.window = t.window[i:]
+}
+
+func isError(res client.Result) bool {
+	return res.Err != nil || res.Status >= 500
+}
+
+func appendBounded(rs []client.Result, res client.Result, max int) []client.Result {
+	rs = append(rs, res)
+	if len(rs) > max {
+		rs = rs[len(rs)-max:]
+	}
+	return rs
+}
+
+// percentiles computes latency percentiles using the nearest-rank method.
+func percentiles(rs []client.Result) Percentiles {
+	if len(rs) == 0 {
+		return Percentiles{}
+	}
+
+	latencies := make([]int, 0, len(rs))
+	for _, res := range rs {
+		latencies = append(latencies, res.Latency)
+	}
+	sort.Ints(latencies)
+
+	at := func(p float64) time.Duration {
+		i := int(p*float64(len(latencies))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i >= len(latencies) {
+			i = len(latencies) - 1
+		}
+		return time.Duration(latencies[i]) * time.Millisecond
+	}
+
+	return Percentiles{
+		P50: at(.5),
+		P90: at(.9),
+		P99: at(.99),
+	}
+}
@@ -0,0 +1,188 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/client"
+	"github.com/wperron/o11yutil/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRunner(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	conf := config.Config{
+		Targets: []config.Target{
+			{Name: "ok", Url: srv.URL, Delay: 5, Workers: 2},
+			{Url: srv.URL + "/fail", Delay: 5},
+		},
+	}
+
+	r, err := New(conf,
+		WithTracerProvider(trace.NewNoopTracerProvider()),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	if err != nil {
+		t.Fatalf("failed to create runner: %s", err)
+	}
+
+	if err := r.Scale("ok", 1); err != ErrNotStarted {
+		t.Errorf("expected ErrNotStarted scaling a stopped runner, got %v", err)
+	}
+
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatalf("failed to start runner: %s", err)
+	}
+	defer r.Stop()
+
+	if err := r.Start(context.Background()); err != ErrAlreadyStarted {
+		t.Errorf("expected ErrAlreadyStarted, got %v", err)
+	}
+
+	waitFor(t, func() bool {
+		for _, s := range r.Stats() {
+			if s.Requests == 0 {
+				return false
+			}
+		}
+		return true
+	})
+
+	stats := r.Stats()
+	if stats[0].Name != "ok" || stats[0].Workers != 2 {
+		t.Errorf("expected target ok with 2 workers, got %s with %d", stats[0].Name, stats[0].Workers)
+	}
+	if stats[0].Errors != 0 {
+		t.Errorf("expected no errors for target ok, got %d", stats[0].Errors)
+	}
+	if stats[1].Name != srv.URL+"/fail" {
+		t.Errorf("expected unnamed target to default to its URL, got %s", stats[1].Name)
+	}
+	if stats[1].Errors == 0 || stats[1].ErrorRate != 1 || len(stats[1].Failures) == 0 {
+		t.Errorf("expected only errors for failing target, got rate %f", stats[1].ErrorRate)
+	}
+	if stats[0].RPS == 0 {
+		t.Errorf("expected a non-zero request rate for target ok")
+	}
+	if last := stats[1].Recent[len(stats[1].Recent)-1]; last.Status != http.StatusInternalServerError {
+		t.Errorf("expected last status 500, got %d", last.Status)
+	}
+
+	if err := r.Scale("ok", 5); err != nil {
+		t.Errorf("failed to scale target: %s", err)
+	}
+	if err := r.Scale("missing", 1); !errors.Is(err, ErrUnknownTarget) {
+		t.Errorf("expected ErrUnknownTarget scaling unknown target, got %v", err)
+	}
+	if workers := r.Stats()[0].Workers; workers != 5 {
+		t.Errorf("expected 5 workers, got %d", workers)
+	}
+
+	if err := r.Pause("ok"); err != nil {
+		t.Errorf("failed to pause target: %s", err)
+	}
+	if s := r.Stats()[0]; !s.Paused || s.Workers != 0 {
+		t.Errorf("expected paused target with no workers, got paused=%t workers=%d", s.Paused, s.Workers)
+	}
+	if err := r.Scale("ok", 3); err != nil {
+		t.Errorf("failed to scale paused target: %s", err)
+	}
+	if workers := r.Stats()[0].Workers; workers != 0 {
+		t.Errorf("expected scaling a paused target not to start workers, got %d", workers)
+	}
+	if err := r.Resume("ok"); err != nil {
+		t.Errorf("failed to resume target: %s", err)
+	}
+	if s := r.Stats()[0]; s.Paused || s.Workers != 3 {
+		t.Errorf("expected resumed target with 3 workers, got paused=%t workers=%d", s.Paused, s.Workers)
+	}
+
+	r.Stop()
+	for _, s := range r.Stats() {
+		if s.Workers != 0 {
+			t.Errorf("expected no workers after stop for target %s, got %d", s.Name, s.Workers)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkersGaugeName(t *testing.T) {
+	conf := config.Config{Targets: []config.Target{{Name: "ok", Url: "http://example.org"}}}
+
+	cases := map[string]client.MetricsOpts{
+		"zombie_workers":    {},
+		"o11y_load_workers": {Namespace: "o11y", Subsystem: "load"},
+	}
+	for want, opts := range cases {
+		reg := prometheus.NewRegistry()
+		r, err := New(conf,
+			WithTracerProvider(trace.NewNoopTracerProvider()),
+			WithRegisterer(reg),
+			WithMetricsOpts(opts),
+		)
+		if err != nil {
+			t.Fatalf("failed to create runner: %s", err)
+		}
+		r.workersGauge.WithLabelValues("ok").Set(1)
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("failed to gather metrics: %s", err)
+		}
+		found := false
+		for _, f := range families {
+			if f.GetName() == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected a %s metric", want)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var rs []client.Result
+	for i := 1; i <= 100; i++ {
+		rs = append(rs, client.Result{Latency: i})
+	}
+
+	p := percentiles(rs)
+	if p.P50 != 50*time.Millisecond || p.P90 != 90*time.Millisecond || p.P99 != 99*time.Millisecond {
+		t.Errorf("expected p50=50ms p90=90ms p99=99ms, got %+v", p)
+	}
+
+	if p := percentiles(nil); p != (Percentiles{}) {
+		t.Errorf("expected zero percentiles without results, got %+v", p)
+	}
+}
+
+func TestInvalidURL(t *testing.T) {
+	for _, u := range []string{"http://[::1", "example.org/path"} {
+		conf := config.Config{Targets: []config.Target{{Url: u}}}
+		if _, err := New(conf, WithRegisterer(prometheus.NewRegistry())); err == nil {
+			t.Errorf("expected an error for target URL %q", u)
+		}
+	}
+}
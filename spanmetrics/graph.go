@@ -0,0 +1,269 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package spanmetrics
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/internal/promutil"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// edgeKey identifies a call between two services by the trace and span ID of
+// the client span, which is the parent of the server span.
+type edgeKey struct {
+	traceID trace.TraceID
+	spanID  trace.SpanID
+}
+
+// edge is a call between two services, waiting for both its client and
+// server spans to end.
+type edge struct {
+	key       edgeKey
+	client    string
+	server    string
+	clientDur float64
+	serverDur float64
+	hasClient bool
+	hasServer bool
+	failed    bool
+	exemplar  prometheus.Labels
+	expires   time.Time
+}
+
+// graph pairs client and server spans into service graph edges.
+type graph struct {
+	ttl     time.Duration
+	max     int
+	exclude func(s sdktrace.ReadOnlySpan) bool
+
+	requests      *prometheus.CounterVec
+	failed        *prometheus.CounterVec
+	clientSeconds *prometheus.HistogramVec
+	serverSeconds *prometheus.HistogramVec
+	unpaired      *prometheus.CounterVec
+	dropped       *prometheus.CounterVec
+
+	mu    sync.Mutex
+	edges map[edgeKey]*list.Element
+	// order of the edges by expiry, oldest first.
+	order *list.List
+	now   func() time.Time
+}
+
+func newGraph(reg prometheus.Registerer, opts Opts) (*graph, error) {
+	g := &graph{
+		ttl:     opts.EdgeTTL,
+		max:     opts.MaxEdges,
+		exclude: opts.ExcludeFromGraph,
+
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "request_total",
+				Help:        "A counter of requests between two services.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"client", "server"},
+		),
+
+		failed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "request_failed_total",
+				Help:        "A counter of failed requests between two services.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"client", "server"},
+		),
+
+		clientSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "request_client_seconds",
+				Help:        "A histogram of request durations between two services, as seen by the client.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.Buckets,
+			},
+			[]string{"client", "server"},
+		),
+
+		serverSeconds: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "request_server_seconds",
+				Help:        "A histogram of request durations between two services, as seen by the server.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.Buckets,
+			},
+			[]string{"client", "server"},
+		),
+
+		// Only one of the client or server labels is set on unpaired and
+		// dropped spans, depending on the side they come from.
+		unpaired: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "unpaired_spans_total",
+				Help:        "A counter of client and server spans whose other half never ended.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"client", "server"},
+		),
+
+		dropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "service_graph",
+				Name:        "dropped_spans_total",
+				Help:        "A counter of client and server spans dropped because too many were waiting for their other half.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"client", "server"},
+		),
+
+		edges: map[edgeKey]*list.Element{},
+		order: list.New(),
+		now:   time.Now,
+	}
+
+	c, err := promutil.Register(reg, g.requests)
+	if err != nil {
+		return nil, err
+	}
+	g.requests = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, g.failed); err != nil {
+		return nil, err
+	}
+	g.failed = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, g.clientSeconds); err != nil {
+		return nil, err
+	}
+	g.clientSeconds = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, g.serverSeconds); err != nil {
+		return nil, err
+	}
+	g.serverSeconds = c.(*prometheus.HistogramVec)
+
+	if c, err = promutil.Register(reg, g.unpaired); err != nil {
+		return nil, err
+	}
+	g.unpaired = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, g.dropped); err != nil {
+		return nil, err
+	}
+	g.dropped = c.(*prometheus.CounterVec)
+
+	return g, nil
+}
+
+// onEnd records the client or server side of an edge, and emits the edge
+// metrics once both sides have ended.
+func (g *graph) onEnd(s sdktrace.ReadOnlySpan, service string) {
+	if g.exclude != nil && g.exclude(s) {
+		return
+	}
+
+	var (
+		key    edgeKey
+		client bool
+	)
+	switch s.SpanKind() {
+	case trace.SpanKindClient, trace.SpanKindProducer:
+		key = edgeKey{traceID: s.SpanContext().TraceID(), spanID: s.SpanContext().SpanID()}
+		client = true
+	case trace.SpanKindServer, trace.SpanKindConsumer:
+		if !s.Parent().IsValid() {
+			return
+		}
+		key = edgeKey{traceID: s.SpanContext().TraceID(), spanID: s.Parent().SpanID()}
+	default:
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.expire(now)
+
+	var e *edge
+	if elem, ok := g.edges[key]; ok {
+		e = elem.Value.(*edge)
+	} else {
+		e = &edge{key: key, expires: now.Add(g.ttl)}
+		if client {
+			e.client = service
+		} else {
+			e.server = service
+		}
+		if len(g.edges) >= g.max {
+			g.dropped.WithLabelValues(e.client, e.server).Inc()
+			return
+		}
+		g.edges[key] = g.order.PushBack(e)
+	}
+
+	dur := s.EndTime().Sub(s.StartTime()).Seconds()
+	if client {
+		e.client, e.clientDur, e.hasClient = service, dur, true
+	} else {
+		e.server, e.serverDur, e.hasServer = service, dur, true
+	}
+	if s.Status().Code == codes.Error {
+		e.failed = true
+	}
+	if exemplar := promutil.Exemplar(s.SpanContext()); exemplar != nil {
+		e.exemplar = exemplar
+	}
+
+	if e.hasClient && e.hasServer {
+		g.order.Remove(g.edges[key])
+		delete(g.edges, key)
+		g.emit(e)
+	}
+}
+
+func (g *graph) emit(e *edge) {
+	exemplar := e.exemplar
+	promutil.Add(g.requests.WithLabelValues(e.client, e.server), 1, exemplar)
+	if e.failed {
+		promutil.Add(g.failed.WithLabelValues(e.client, e.server), 1, exemplar)
+	}
+	promutil.Observe(g.clientSeconds.WithLabelValues(e.client, e.server), e.clientDur, exemplar)
+	promutil.Observe(g.serverSeconds.WithLabelValues(e.client, e.server), e.serverDur, exemplar)
+}
+
+// expire counts the edges that expired before now as unpaired, or every
+// edge if now is the zero time. The lock must be held.
+func (g *graph) expire(now time.Time) {
+	for elem := g.order.Front(); elem != nil; elem = g.order.Front() {
+		e := elem.Value.(*edge)
+		if !now.IsZero() && e.expires.After(now) {
+			return
+		}
+		g.order.Remove(elem)
+		delete(g.edges, e.key)
+		g.unpaired.WithLabelValues(e.client, e.server).Inc()
+	}
+}
+
+// expireAll counts every edge still waiting for its other half as unpaired.
+func (g *graph) expireAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire(time.Time{})
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package spanmetrics contains an implementation of OpenTelemetry Go's
+// SpanProcessor interface that derives Prometheus metrics from ended spans,
+// without the need for a collector.
+//
+// It produces the rate, errors and duration of spans keyed by service, span
+// name, kind and status, and the requests between services for a service
+// graph, pairing the client spans of one service with the server spans of
+// another. Metric names follow those of Tempo's metrics generator so that
+// Grafana's service graph view works out of the box.
+package spanmetrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wperron/o11yutil/internal/promutil"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ sdktrace.SpanProcessor = &Processor{}
+
+const (
+	defaultNamespace = "traces"
+	defaultEdgeTTL   = 10 * time.Second
+	defaultMaxEdges  = 10000
+
+	unknownService = "unknown_service"
+)
+
+// Opts configures the metrics of a Processor. The zero value creates the
+// metrics with their default names and buckets.
+type Opts struct {
+	// Namespace is prepended to every metric name. Defaults to "traces".
+	Namespace string
+
+	// ConstLabels are added to every metric.
+	ConstLabels prometheus.Labels
+
+	// Buckets of the duration histograms, in seconds. Defaults to
+	// prometheus.DefBuckets.
+	Buckets []float64
+
+	// EdgeTTL is how long a client or server span waits for its other half
+	// before being counted as unpaired. Defaults to 10s.
+	EdgeTTL time.Duration
+
+	// MaxEdges is the maximum number of spans waiting for their other half.
+	// Spans are dropped past that limit. Defaults to 10000.
+	MaxEdges int
+
+	// ExcludeFromGraph reports whether a span is left out of the service
+	// graph, like client spans calling services traced by another process,
+	// whose other half never ends here. Defaults to pairing every client,
+	// producer, server and consumer span.
+	ExcludeFromGraph func(s sdktrace.ReadOnlySpan) bool
+}
+
+// Processor aggregates ended spans into Prometheus metrics. A single
+// Processor should be shared by the TracerProviders of every service in the
+// process, so that calls between them can be paired into service graph
+// edges.
+type Processor struct {
+	calls    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	graph *graph
+}
+
+// New creates a Processor and registers its metrics with reg. If identical
+// metrics are already registered with reg, those are reused.
+func New(reg prometheus.Registerer, opts Opts) (*Processor, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+	if opts.EdgeTTL <= 0 {
+		opts.EdgeTTL = defaultEdgeTTL
+	}
+	if opts.MaxEdges <= 0 {
+		opts.MaxEdges = defaultMaxEdges
+	}
+
+	p := &Processor{
+		calls: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "spanmetrics",
+				Name:        "calls_total",
+				Help:        "A counter of ended spans.",
+				ConstLabels: opts.ConstLabels,
+			},
+			[]string{"service", "span_name", "span_kind", "status_code"},
+		),
+
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   opts.Namespace,
+				Subsystem:   "spanmetrics",
+				Name:        "latency",
+				Help:        "A histogram of span durations in seconds.",
+				ConstLabels: opts.ConstLabels,
+				Buckets:     opts.Buckets,
+			},
+			[]string{"service", "span_name", "span_kind", "status_code"},
+		),
+	}
+
+	c, err := promutil.Register(reg, p.calls)
+	if err != nil {
+		return nil, err
+	}
+	p.calls = c.(*prometheus.CounterVec)
+
+	if c, err = promutil.Register(reg, p.duration); err != nil {
+		return nil, err
+	}
+	p.duration = c.(*prometheus.HistogramVec)
+
+	if p.graph, err = newGraph(reg, opts); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Processor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {}
+
+func (p *Processor) OnEnd(s sdktrace.ReadOnlySpan) {
+	service := serviceName(s)
+	exemplar := promutil.Exemplar(s.SpanContext())
+	labels := []string{service, s.Name(), spanKind(s.SpanKind()), statusCode(s.Status().Code)}
+
+	promutil.Add(p.calls.WithLabelValues(labels...), 1, exemplar)
+	promutil.Observe(p.duration.WithLabelValues(labels...), s.EndTime().Sub(s.StartTime()).Seconds(), exemplar)
+
+	p.graph.onEnd(s, service)
+}
+
+func (p *Processor) ForceFlush(ctx context.Context) error { return nil }
+
+// Shutdown counts the spans still waiting for their other half as unpaired.
+func (p *Processor) Shutdown(ctx context.Context) error {
+	p.graph.expireAll()
+	return nil
+}
+
+// serviceName returns the service.name resource attribute of s.
+func serviceName(s sdktrace.ReadOnlySpan) string {
+	if s.Resource() == nil {
+		return unknownService
+	}
+	for _, kv := range s.Resource().Attributes() {
+		if kv.Key == semconv.ServiceNameKey {
+			return kv.Value.AsString()
+		}
+	}
+	return unknownService
+}
+
+// spanKind returns the OTLP name of kind, e.g. SPAN_KIND_SERVER.
+func spanKind(kind trace.SpanKind) string {
+	return "SPAN_KIND_" + strings.ToUpper(kind.String())
+}
+
+// statusCode returns the OTLP name of code, e.g. STATUS_CODE_ERROR.
+func statusCode(code codes.Code) string {
+	return "STATUS_CODE_" + strings.ToUpper(code.String())
+}
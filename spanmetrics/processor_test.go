@@ -0,0 +1,168 @@
+package spanmetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newProvider(p *Processor, service string) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+		sdktrace.WithSpanProcessor(p),
+	)
+}
+
+// call makes a client span in the client service and a server span in the
+// server service, as if the trace context was propagated over the network.
+func call(ctx context.Context, client, server trace.Tracer, fail bool) {
+	ctx, cs := client.Start(ctx, "GET", trace.WithSpanKind(trace.SpanKindClient))
+	remote := trace.ContextWithRemoteSpanContext(context.Background(), cs.SpanContext())
+	_, ss := server.Start(remote, "/", trace.WithSpanKind(trace.SpanKindServer))
+	if fail {
+		ss.SetStatus(codes.Error, "failed")
+	}
+	ss.End()
+	cs.End()
+}
+
+func TestProcessor(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p, err := New(reg, Opts{})
+	if err != nil {
+		t.Fatalf("failed to create processor: %s", err)
+	}
+
+	frontend := newProvider(p, "frontend").Tracer("test")
+	backend := newProvider(p, "backend").Tracer("test")
+
+	ctx, root := frontend.Start(context.Background(), "root", trace.WithSpanKind(trace.SpanKindServer))
+	call(ctx, frontend, backend, false)
+	call(ctx, frontend, backend, true)
+	root.End()
+
+	calls := map[[4]string]float64{
+		{"frontend", "root", "SPAN_KIND_SERVER", "STATUS_CODE_UNSET"}: 1,
+		{"frontend", "GET", "SPAN_KIND_CLIENT", "STATUS_CODE_UNSET"}:  2,
+		{"backend", "/", "SPAN_KIND_SERVER", "STATUS_CODE_UNSET"}:     1,
+		{"backend", "/", "SPAN_KIND_SERVER", "STATUS_CODE_ERROR"}:     1,
+	}
+	for labels, want := range calls {
+		if got := testutil.ToFloat64(p.calls.WithLabelValues(labels[:]...)); got != want {
+			t.Errorf("expected %v calls for %v, got %v", want, labels, got)
+		}
+	}
+
+	if got := testutil.ToFloat64(p.graph.requests.WithLabelValues("frontend", "backend")); got != 2 {
+		t.Errorf("expected 2 requests from frontend to backend, got %v", got)
+	}
+	if got := testutil.ToFloat64(p.graph.failed.WithLabelValues("frontend", "backend")); got != 1 {
+		t.Errorf("expected 1 failed request from frontend to backend, got %v", got)
+	}
+	if n := testutil.CollectAndCount(p.graph.unpaired); n != 0 {
+		t.Errorf("expected no unpaired spans, got %d series", n)
+	}
+
+	// The root server span has no parent, so it isn't waiting for a client.
+	if len(p.graph.edges) != 0 {
+		t.Errorf("expected no pending edges, got %d", len(p.graph.edges))
+	}
+}
+
+func TestUnpaired(t *testing.T) {
+	p, err := New(prometheus.NewRegistry(), Opts{EdgeTTL: time.Minute, MaxEdges: 2})
+	if err != nil {
+		t.Fatalf("failed to create processor: %s", err)
+	}
+	now := time.Now()
+	p.graph.now = func() time.Time { return now }
+
+	tracer := newProvider(p, "zombie").Tracer("test")
+	for i := 0; i < 3; i++ {
+		_, s := tracer.Start(context.Background(), "GET", trace.WithSpanKind(trace.SpanKindClient))
+		s.End()
+	}
+
+	if got := testutil.ToFloat64(p.graph.dropped.WithLabelValues("zombie", "")); got != 1 {
+		t.Errorf("expected 1 dropped span, got %v", got)
+	}
+
+	// Ending another span past the TTL expires the pending ones.
+	now = now.Add(2 * time.Minute)
+	_, s := tracer.Start(context.Background(), "GET", trace.WithSpanKind(trace.SpanKindClient))
+	s.End()
+	if got := testutil.ToFloat64(p.graph.unpaired.WithLabelValues("zombie", "")); got != 2 {
+		t.Errorf("expected 2 unpaired spans, got %v", got)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := testutil.ToFloat64(p.graph.unpaired.WithLabelValues("zombie", "")); got != 3 {
+		t.Errorf("expected 3 unpaired spans after shutdown, got %v", got)
+	}
+}
+
+func TestExcludeFromGraph(t *testing.T) {
+	p, err := New(prometheus.NewRegistry(), Opts{
+		ExcludeFromGraph: func(s sdktrace.ReadOnlySpan) bool { return s.SpanKind() == trace.SpanKindClient },
+	})
+	if err != nil {
+		t.Fatalf("failed to create processor: %s", err)
+	}
+
+	tracer := newProvider(p, "zombie").Tracer("test")
+	_, s := tracer.Start(context.Background(), "zombie.ping", trace.WithSpanKind(trace.SpanKindClient))
+	s.End()
+
+	if len(p.graph.edges) != 0 {
+		t.Errorf("expected no pending edges, got %d", len(p.graph.edges))
+	}
+	if got := testutil.ToFloat64(p.calls.WithLabelValues("zombie", "zombie.ping", "SPAN_KIND_CLIENT", "STATUS_CODE_UNSET")); got != 1 {
+		t.Errorf("expected the span to be counted, got %v", got)
+	}
+}
+
+// recordOnly records every span without sampling it.
+type recordOnly struct{}
+
+func (recordOnly) ShouldSample(sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return sdktrace.SamplingResult{Decision: sdktrace.RecordOnly}
+}
+
+func (recordOnly) Description() string { return "RecordOnly" }
+
+func TestExemplars(t *testing.T) {
+	p, err := New(prometheus.NewRegistry(), Opts{})
+	if err != nil {
+		t.Fatalf("failed to create processor: %s", err)
+	}
+
+	for _, sampler := range []sdktrace.Sampler{sdktrace.AlwaysSample(), recordOnly{}} {
+		tracer := sdktrace.NewTracerProvider(
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("zombie"))),
+			sdktrace.WithSampler(sampler),
+			sdktrace.WithSpanProcessor(p),
+		).Tracer("test")
+		_, s := tracer.Start(context.Background(), sampler.Description())
+		s.End()
+
+		m := &dto.Metric{}
+		if err := p.calls.WithLabelValues("zombie", sampler.Description(), "SPAN_KIND_INTERNAL", "STATUS_CODE_UNSET").(prometheus.Metric).Write(m); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sampled := s.SpanContext().IsSampled()
+		if got := m.GetCounter().GetExemplar() != nil; got != sampled {
+			t.Errorf("%s: expected exemplar to be set %t, got %t", sampler.Description(), sampled, got)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+package topology
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInjected is recorded on spans failed because of their error_percent.
+var ErrInjected = errors.New("injected error")
+
+// Generator produces traces following a Topology.
+type Generator struct {
+	tracer   trace.Tracer
+	root     string
+	maxDepth int
+	ops      map[string]*operation
+	rnd      *rand.Rand
+}
+
+// operation is an Operation with its span options resolved once.
+type operation struct {
+	Operation
+	opts []trace.SpanStartOption
+}
+
+// NewGenerator creates a Generator starting spans with tracer. The topology
+// must be valid.
+func NewGenerator(t *Topology, tracer trace.Tracer) (*Generator, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &Generator{
+		tracer:   tracer,
+		root:     t.Root,
+		maxDepth: t.MaxDepth,
+		ops:      make(map[string]*operation, len(t.Operations)),
+		rnd:      rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano()).(rand.Source64)}),
+	}
+	if g.maxDepth <= 0 {
+		g.maxDepth = defaultMaxDepth
+	}
+
+	for _, op := range t.Operations {
+		kind, _ := spanKind(op.Kind)
+		attrs, _ := attributes(op.Attributes)
+		g.ops[op.Name] = &operation{
+			Operation: op,
+			opts: []trace.SpanStartOption{
+				trace.WithSpanKind(kind),
+				trace.WithAttributes(attrs...),
+			},
+		}
+	}
+
+	return g, nil
+}
+
+// Run generates a trace starting at the root operation, as a child of the
+// span in ctx. It returns an error if the root operation failed.
+func (g *Generator) Run(ctx context.Context) error {
+	return g.run(ctx, g.ops[g.root], 0)
+}
+
+func (g *Generator) run(ctx context.Context, op *operation, depth int) error {
+	ctx, span := g.tracer.Start(ctx, op.Name, op.opts...)
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("depth", depth))
+
+	select {
+	case <-ctx.Done():
+		span.RecordError(ctx.Err())
+		span.SetStatus(codes.Error, ctx.Err().Error())
+		return ctx.Err()
+	case <-time.After(op.Duration.Sample(g.rnd)):
+	}
+
+	if depth < g.maxDepth {
+		for _, c := range op.Children {
+			g.call(ctx, c, depth+1)
+		}
+	}
+
+	if op.ErrorPercent > 0 && g.rnd.Float64()*100 < op.ErrorPercent {
+		span.RecordError(ErrInjected)
+		span.SetStatus(codes.Error, ErrInjected.Error())
+		return ErrInjected
+	}
+	return nil
+}
+
+// call makes the calls to a child operation, waiting for all of them to
+// complete. Errors of children are recorded on their own spans only.
+func (g *Generator) call(ctx context.Context, c Call, depth int) {
+	count := c.Count
+	if count == 0 {
+		count = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		if c.Percent != nil && g.rnd.Float64()*100 >= *c.Percent {
+			continue
+		}
+
+		op := g.ops[c.Operation]
+		if !c.Parallel {
+			_ = g.run(ctx, op, depth)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = g.run(ctx, op, depth)
+		}()
+	}
+	wg.Wait()
+}
+
+// attributes converts YAML attribute values to span attributes.
+func attributes(m map[string]interface{}) ([]attribute.KeyValue, error) {
+	attrs := make([]attribute.KeyValue, 0, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(k, v))
+		case int:
+			attrs = append(attrs, attribute.Int(k, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(k, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(k, v))
+		case []interface{}:
+			kv, err := sliceAttribute(k, v)
+			if err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, kv)
+		default:
+			return nil, fmt.Errorf("unsupported value for attribute %s: %v", k, v)
+		}
+	}
+	return attrs, nil
+}
+
+// sliceAttribute converts a homogeneous YAML list to a slice attribute.
+func sliceAttribute(k string, vs []interface{}) (attribute.KeyValue, error) {
+	if len(vs) == 0 {
+		return attribute.StringSlice(k, nil), nil
+	}
+
+	switch vs[0].(type) {
+	case string:
+		s := make([]string, 0, len(vs))
+		for _, v := range vs {
+			str, ok := v.(string)
+			if !ok {
+				return attribute.KeyValue{}, fmt.Errorf("mixed types in attribute %s", k)
+			}
+			s = append(s, str)
+		}
+		return attribute.StringSlice(k, s), nil
+	case int:
+		s := make([]int, 0, len(vs))
+		for _, v := range vs {
+			i, ok := v.(int)
+			if !ok {
+				return attribute.KeyValue{}, fmt.Errorf("mixed types in attribute %s", k)
+			}
+			s = append(s, i)
+		}
+		return attribute.IntSlice(k, s), nil
+	case float64:
+		s := make([]float64, 0, len(vs))
+		for _, v := range vs {
+			f, ok := v.(float64)
+			if !ok {
+				return attribute.KeyValue{}, fmt.Errorf("mixed types in attribute %s", k)
+			}
+			s = append(s, f)
+		}
+		return attribute.Float64Slice(k, s), nil
+	case bool:
+		s := make([]bool, 0, len(vs))
+		for _, v := range vs {
+			b, ok := v.(bool)
+			if !ok {
+				return attribute.KeyValue{}, fmt.Errorf("mixed types in attribute %s", k)
+			}
+			s = append(s, b)
+		}
+		return attribute.BoolSlice(k, s), nil
+	default:
+		return attribute.KeyValue{}, fmt.Errorf("unsupported value for attribute %s: %v", k, vs)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2021 William Perron. All rights reserved. MIT License.
+
+// Package topology generates synthetic traces from a description of a
+// service's operations: their duration distribution, span kind, attributes,
+// error rate and children, which can be called sequentially or fanned out in
+// parallel. It's used to produce varied, realistic trace shapes to test trace
+// queries and sampling policies against.
+package topology
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultMaxDepth = 10
+
+// maxSpansPerTrace bounds the number of spans a topology can generate in a
+// single trace, so that counts multiplied across levels don't exhaust memory.
+const maxSpansPerTrace = 10000
+
+// Topology of a synthetic service.
+type Topology struct {
+	// Root is the name of the operation called for every request.
+	Root string `yaml:"root"`
+
+	// MaxDepth bounds the depth of generated traces, which stops recursive
+	// operations. Defaults to 10.
+	MaxDepth int `yaml:"max_depth,omitempty"`
+
+	// Operations that can be called.
+	Operations []Operation `yaml:"operations"`
+}
+
+// Operation generates a span.
+type Operation struct {
+	Name string `yaml:"name"`
+
+	// Kind of the span, one of `internal`, `server`, `client`, `producer` or
+	// `consumer`. Defaults to `internal`.
+	Kind string `yaml:"kind,omitempty"`
+
+	// Duration of the operation itself, excluding its children.
+	Duration Distribution `yaml:"duration"`
+
+	// Attributes set on the span. Values can be strings, numbers, booleans or
+	// lists of those.
+	Attributes map[string]interface{} `yaml:"attributes,omitempty"`
+
+	// ErrorPercent of spans ending with an error status.
+	ErrorPercent float64 `yaml:"error_percent,omitempty"`
+
+	// Children called once the operation's own duration elapsed.
+	Children []Call `yaml:"children,omitempty"`
+}
+
+// Call of a child operation.
+type Call struct {
+	Operation string `yaml:"operation"`
+
+	// Count of calls made to the operation. Defaults to 1.
+	Count int `yaml:"count,omitempty"`
+
+	// Parallel calls are fanned out concurrently rather than made one after
+	// the other.
+	Parallel bool `yaml:"parallel,omitempty"`
+
+	// Percent chance of each call being made. Defaults to 100.
+	Percent *float64 `yaml:"percent,omitempty"`
+}
+
+// Distribution of durations, expressed in milliseconds.
+type Distribution struct {
+	// Distribution is one of `fixed` (Mean), `uniform` (Min to Max), `normal`
+	// (Mean and StdDev) or `exponential` (Mean). Defaults to `fixed`.
+	Distribution string `yaml:"distribution,omitempty"`
+
+	Min    int64 `yaml:"min,omitempty"`
+	Max    int64 `yaml:"max,omitempty"`
+	Mean   int64 `yaml:"mean,omitempty"`
+	StdDev int64 `yaml:"stddev,omitempty"`
+}
+
+func Load(s string) (*Topology, error) {
+	t := &Topology{}
+
+	if err := yaml.Unmarshal([]byte(s), t); err != nil {
+		return nil, err
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func LoadFile(fp string) (*Topology, error) {
+	bs, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %s", fp, err)
+	}
+
+	t, err := Load(string(bs))
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file: %s", err)
+	}
+	return t, nil
+}
+
+// Validate checks that every referenced operation exists, that kinds,
+// distributions and attributes are valid, and that a trace can't have more
+// than 10000 spans.
+func (t *Topology) Validate() error {
+	ops := make(map[string]bool, len(t.Operations))
+	for _, op := range t.Operations {
+		if op.Name == "" {
+			return fmt.Errorf("operation without a name")
+		}
+		if ops[op.Name] {
+			return fmt.Errorf("duplicate operation %s", op.Name)
+		}
+		ops[op.Name] = true
+	}
+
+	if !ops[t.Root] {
+		return fmt.Errorf("unknown root operation %q", t.Root)
+	}
+
+	for _, op := range t.Operations {
+		if _, err := spanKind(op.Kind); err != nil {
+			return fmt.Errorf("operation %s: %w", op.Name, err)
+		}
+		if err := op.Duration.Validate(); err != nil {
+			return fmt.Errorf("operation %s: %w", op.Name, err)
+		}
+		if op.ErrorPercent < 0 || op.ErrorPercent > 100 {
+			return fmt.Errorf("operation %s: error_percent must be between 0 and 100", op.Name)
+		}
+		if _, err := attributes(op.Attributes); err != nil {
+			return fmt.Errorf("operation %s: %w", op.Name, err)
+		}
+		for _, c := range op.Children {
+			if !ops[c.Operation] {
+				return fmt.Errorf("operation %s: unknown child operation %q", op.Name, c.Operation)
+			}
+			if c.Count < 0 {
+				return fmt.Errorf("operation %s: negative count for child %s", op.Name, c.Operation)
+			}
+			if c.Percent != nil && (*c.Percent < 0 || *c.Percent > 100) {
+				return fmt.Errorf("operation %s: percent of child %s must be between 0 and 100", op.Name, c.Operation)
+			}
+		}
+	}
+
+	if t.spans() > maxSpansPerTrace {
+		return fmt.Errorf("traces can have more than %d spans", maxSpansPerTrace)
+	}
+
+	return nil
+}
+
+// spans returns the number of spans in a trace if every call is made, or
+// maxSpansPerTrace+1 if there are more than that.
+func (t *Topology) spans() int {
+	maxDepth := t.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+
+	ops := make(map[string]Operation, len(t.Operations))
+	for _, op := range t.Operations {
+		ops[op.Name] = op
+	}
+
+	// Recursive operations are called at every depth, so the number of
+	// spans under an operation depends on its depth.
+	type key struct {
+		op    string
+		depth int
+	}
+	memo := map[key]int{}
+
+	var count func(op string, depth int) int
+	count = func(op string, depth int) int {
+		k := key{op, depth}
+		if n, ok := memo[k]; ok {
+			return n
+		}
+
+		n := 1
+		if depth < maxDepth {
+			for _, c := range ops[op].Children {
+				calls := c.Count
+				if calls == 0 {
+					calls = 1
+				}
+				if calls > maxSpansPerTrace {
+					n = maxSpansPerTrace + 1
+					break
+				}
+				n += calls * count(c.Operation, depth+1)
+				if n > maxSpansPerTrace {
+					n = maxSpansPerTrace + 1
+					break
+				}
+			}
+		}
+		memo[k] = n
+		return n
+	}
+	return count(t.Root, 0)
+}
+
+// Validate checks that the distribution is known and its bounds consistent.
+func (d Distribution) Validate() error {
+	switch d.Distribution {
+	case "", "fixed", "normal", "exponential":
+	case "uniform":
+		if d.Max < d.Min {
+			return fmt.Errorf("duration max %d is lower than min %d", d.Max, d.Min)
+		}
+	default:
+		return fmt.Errorf("unknown duration distribution %q", d.Distribution)
+	}
+	return nil
+}
+
+// Sample returns a duration drawn from the distribution, never negative.
+func (d Distribution) Sample(rnd *rand.Rand) time.Duration {
+	var ms float64
+	switch d.Distribution {
+	case "", "fixed":
+		ms = float64(d.Mean)
+	case "uniform":
+		ms = float64(d.Min) + rnd.Float64()*float64(d.Max-d.Min)
+	case "normal":
+		ms = rnd.NormFloat64()*float64(d.StdDev) + float64(d.Mean)
+	case "exponential":
+		ms = rnd.ExpFloat64() * float64(d.Mean)
+	}
+	return time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+}
+
+func spanKind(kind string) (trace.SpanKind, error) {
+	switch kind {
+	case "", "internal":
+		return trace.SpanKindInternal, nil
+	case "server":
+		return trace.SpanKindServer, nil
+	case "client":
+		return trace.SpanKindClient, nil
+	case "producer":
+		return trace.SpanKindProducer, nil
+	case "consumer":
+		return trace.SpanKindConsumer, nil
+	default:
+		return trace.SpanKindUnspecified, fmt.Errorf("unknown span kind %q", kind)
+	}
+}
+
+// lockedSource makes a rand.Source safe for concurrent use by the fanned out
+// calls of a trace.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
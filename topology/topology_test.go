@@ -0,0 +1,159 @@
+package topology
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLoad(t *testing.T) {
+	if _, err := LoadFile("../topology.yaml"); err != nil {
+		t.Errorf("failed to load example topology: %s", err)
+	}
+
+	invalid := map[string]string{
+		"unknown root": `
+root: missing
+operations:
+  - name: a
+`,
+		"unknown child": `
+root: a
+operations:
+  - name: a
+    children:
+      - operation: b
+`,
+		"unknown kind": `
+root: a
+operations:
+  - name: a
+    kind: sideways
+`,
+		"unknown distribution": `
+root: a
+operations:
+  - name: a
+    duration:
+      distribution: zipf
+`,
+		"nested attribute": `
+root: a
+operations:
+  - name: a
+    attributes:
+      nested: {a: b}
+`,
+		"too many spans": `
+root: a
+operations:
+  - name: a
+    children:
+      - operation: b
+        count: 200
+  - name: b
+    children:
+      - operation: c
+        count: 100
+  - name: c
+`,
+		"recursive fan out": `
+root: a
+max_depth: 20
+operations:
+  - name: a
+    children:
+      - operation: a
+        count: 2
+`,
+	}
+	for name, s := range invalid {
+		if _, err := Load(s); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	topo, err := Load(`
+root: root
+max_depth: 3
+operations:
+  - name: root
+    kind: server
+    attributes:
+      route: /
+      retries: 2
+      tags: [a, b]
+    children:
+      - operation: fanout
+        count: 4
+        parallel: true
+      - operation: loop
+  - name: fanout
+    kind: client
+    duration:
+      distribution: uniform
+      min: 1
+      max: 2
+  - name: loop
+    children:
+      - operation: loop
+`)
+	if err != nil {
+		t.Fatalf("failed to load topology: %s", err)
+	}
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+
+	g, err := NewGenerator(topo, tp.Tracer("test"))
+	if err != nil {
+		t.Fatalf("failed to create generator: %s", err)
+	}
+	if err := g.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	counts := map[string]int{}
+	for _, s := range rec.Ended() {
+		counts[s.Name()]++
+		if s.Name() == "root" && s.SpanKind() != trace.SpanKindServer {
+			t.Errorf("expected root to be a server span, got %s", s.SpanKind())
+		}
+		if s.Name() == "fanout" && s.SpanKind() != trace.SpanKindClient {
+			t.Errorf("expected fanout to be a client span, got %s", s.SpanKind())
+		}
+	}
+
+	// The loop is called at depth 1 and recurses until the max depth of 3.
+	want := map[string]int{"root": 1, "fanout": 4, "loop": 3}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("expected %d %s spans, got %d", n, name, counts[name])
+		}
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	topo, err := Load(`
+root: root
+operations:
+  - name: root
+    error_percent: 100
+`)
+	if err != nil {
+		t.Fatalf("failed to load topology: %s", err)
+	}
+
+	g, err := NewGenerator(topo, trace.NewNoopTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatalf("failed to create generator: %s", err)
+	}
+	if err := g.Run(context.Background()); err != ErrInjected {
+		t.Errorf("expected injected error, got %v", err)
+	}
+}